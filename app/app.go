@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/vabshere/vernacular-auth/config"
+	"github.com/vabshere/vernacular-auth/mail"
+	"github.com/vabshere/vernacular-auth/models"
+	"github.com/vabshere/vernacular-auth/utils"
+	"github.com/vabshere/vernacular-auth/utils/session"
+)
+
+// App is one instance of the service along with everything it owns. Instances share no state, so several can run in one process.
+type App struct {
+	Config   *config.Config
+	Users    models.UserStore
+	Sessions *session.Manager
+	// SessionIndex lists the signed in sessions of every user so they can revoke them
+	SessionIndex models.SessionIndex
+	// Mailer sends the emails of the app, e.g. password reset links
+	Mailer mail.Mailer
+	// VerifyKey signs the email verification tokens
+	VerifyKey []byte
+	Logger    *log.Logger
+	// Router serves the routes of the app, set by routes.Init
+	Router http.Handler
+	// background counts the work started by Go
+	background sync.WaitGroup
+}
+
+// New returns an App storing users in db, nil with the memory driver, and starts collecting its expired sessions until Close.
+// A nil logger logs through the standard logger.
+func New(cfg *config.Config, db *sql.DB, logger *log.Logger) (*App, error) {
+	users, err := models.NewStore(cfg.Database.Driver, db)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := models.NewSessionIndex(cfg.Database.Driver, db)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := utils.NewSessionManager(cfg.Session, db, cfg.Database.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	mailer, err := mail.New(cfg.Mail, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := cfg.Auth.DecodeVerifyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	sessions.StartGC(logger)
+	return &App{Config: cfg, Users: users, Sessions: sessions, SessionIndex: index, Mailer: mailer, VerifyKey: key, Logger: logger}, nil
+}
+
+// ServeHTTP serves r with the router of the app
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.Router.ServeHTTP(w, r)
+}
+
+// Go runs f in the background, after the response of the request starting it, e.g. to send an email. Close waits for it.
+func (a *App) Go(f func(ctx context.Context)) {
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		f(context.Background())
+	}()
+}
+
+// Close waits for the work started by Go, stops the background work of the app and releases its session store. db is left to its owner.
+// It gives up waiting when ctx is done.
+func (a *App) Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		a.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	return a.Sessions.Close(ctx)
+}
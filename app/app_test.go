@@ -0,0 +1,413 @@
+package app_test
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/app"
+	"github.com/vabshere/vernacular-auth/config"
+	"github.com/vabshere/vernacular-auth/mail"
+	"github.com/vabshere/vernacular-auth/routes"
+	"github.com/vabshere/vernacular-auth/utils/totp"
+)
+
+func newTestApp(t *testing.T, configure ...func(*config.Config)) *app.App {
+	cfg := config.Default()
+	cfg.Database.Driver = "memory"
+	cfg.Mail.Provider = "memory"
+	for _, f := range configure {
+		f(cfg)
+	}
+
+	a, err := app.New(cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes.Init(a)
+	return a
+}
+
+func signUp(a *app.App, email string) *httptest.ResponseRecorder {
+	form := url.Values{"name": {"foo"}, "email": {email}, "password": {"pass"}}
+	r := httptest.NewRequest(http.MethodPost, "/reg", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w
+}
+
+func TestInstancesAreIndependent(t *testing.T) {
+	t.Parallel()
+	first, second := newTestApp(t), newTestApp(t)
+	for _, a := range []*app.App{first, second} {
+		if w := signUp(a, "foo@bar.com"); !strings.Contains(w.Body.String(), `"code":0`) {
+			t.Fatalf("sign up failed: %s", w.Body.String())
+		}
+	}
+
+	w := signUp(first, "bar@bar.com")
+	r := httptest.NewRequest(http.MethodGet, "/home", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	w = httptest.NewRecorder()
+	first.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "bar@bar.com") {
+		t.Errorf("got %d %s want the signed in user", w.Code, w.Body.String())
+	}
+
+	if _, err := second.Users.GetUserByEmail("bar@bar.com"); err == nil {
+		t.Errorf("a user of the first app was stored in the second")
+	}
+}
+
+func TestSessionRevocation(t *testing.T) {
+	a := newTestApp(t)
+	form := url.Values{"email": {"foo@bar.com"}, "password": {"pass"}}
+	r := httptest.NewRequest(http.MethodPost, "/oauth", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	devices := []*http.Cookie{signUp(a, "foo@bar.com").Result().Cookies()[0]}
+	a.ServeHTTP(w, r)
+	devices = append(devices, w.Result().Cookies()[0])
+
+	request := func(method, path string, device *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.AddCookie(device)
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		return w
+	}
+
+	w = request(http.MethodGet, "/sessions", devices[0])
+	var listed struct {
+		Data []struct {
+			Id      string `json:"id"`
+			Current bool   `json:"current"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(listed.Data) != 2 || listed.Data[0].Current == listed.Data[1].Current {
+		t.Fatalf("got %+v want two sessions, one of them current", listed.Data)
+	}
+
+	other := listed.Data[0].Id
+	if listed.Data[0].Current {
+		other = listed.Data[1].Id
+	}
+
+	if w := request(http.MethodDelete, "/sessions/"+other, devices[0]); w.Code != http.StatusOK {
+		t.Fatalf("revoking failed with %d", w.Code)
+	}
+
+	if w := request(http.MethodGet, "/home", devices[1]); w.Code != http.StatusUnauthorized {
+		t.Errorf("got %d want the revoked device signed out", w.Code)
+	}
+
+	if w := request(http.MethodGet, "/home", devices[0]); w.Code != http.StatusOK {
+		t.Errorf("got %d want the current device still signed in", w.Code)
+	}
+}
+
+func TestPasswordChangeSignsOutEverywhere(t *testing.T) {
+	a := newTestApp(t)
+	stolen := signUp(a, "foo@bar.com").Result().Cookies()[0]
+	signIn := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {"foo@bar.com"}, "password": {password}}
+		r := httptest.NewRequest(http.MethodPost, "/oauth", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		return w
+	}
+	device := signIn("pass").Result().Cookies()[0]
+
+	form := url.Values{"password": {"pass"}, "new_password": {"secret"}}
+	r := httptest.NewRequest(http.MethodPost, "/password", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(device)
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"code":0`) {
+		t.Fatalf("password change failed: %s", w.Body.String())
+	}
+
+	home := func(cookie *http.Cookie) int {
+		r := httptest.NewRequest(http.MethodGet, "/home", nil)
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := home(stolen); code != http.StatusUnauthorized {
+		t.Errorf("got %d want the other session signed out", code)
+	}
+
+	if code := home(w.Result().Cookies()[0]); code != http.StatusOK {
+		t.Errorf("got %d want the changing session still signed in", code)
+	}
+
+	if w := signIn("secret"); !strings.Contains(w.Body.String(), `"code":0`) {
+		t.Errorf("sign in with the new password failed: %s", w.Body.String())
+	}
+}
+
+// waitForMail returns the emails sent by mailer once there are n of them, or after a second, as some are sent in the background
+func waitForMail(mailer *mail.MemoryMailer, n int) []mail.Message {
+	deadline := time.Now().Add(time.Second)
+	for len(mailer.Messages()) < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	return mailer.Messages()
+}
+
+func TestPasswordReset(t *testing.T) {
+	a := newTestApp(t)
+	stolen := signUp(a, "foo@bar.com").Result().Cookies()[0]
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		return w
+	}
+
+	mailer := a.Mailer.(*mail.MemoryMailer)
+	sent := len(mailer.Messages())
+	if w := post("/password/reset", url.Values{"email": {"nobody@bar.com"}}); !strings.Contains(w.Body.String(), `"code":0`) || len(mailer.Messages()) != sent {
+		t.Fatalf("got %s and %d emails want success without email for an unknown address", w.Body.String(), len(mailer.Messages())-sent)
+	}
+
+	post("/password/reset", url.Values{"email": {"foo@bar.com"}})
+	messages := waitForMail(mailer, sent+1)[sent:]
+	if len(messages) != 1 || messages[0].To != "foo@bar.com" {
+		t.Fatalf("got %+v want one email to foo@bar.com", messages)
+	}
+
+	if w := post("/password/reset", url.Values{"email": {"foo@bar.com"}}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("got %d want a second reset right away throttled", w.Code)
+	}
+
+	token := strings.Split(messages[0].Body, "\n")[2]
+	confirm := url.Values{"token": {token}, "new_password": {"secret"}}
+	if w := post("/password/reset/confirm", confirm); !strings.Contains(w.Body.String(), `"code":0`) {
+		t.Fatalf("confirming failed: %s", w.Body.String())
+	}
+
+	if w := post("/password/reset/confirm", confirm); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d want a used token rejected", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/home", nil)
+	r.AddCookie(stolen)
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got %d want sessions from before the reset signed out", w.Code)
+	}
+
+	if w := post("/oauth", url.Values{"email": {"foo@bar.com"}, "password": {"secret"}}); !strings.Contains(w.Body.String(), `"code":0`) {
+		t.Errorf("sign in with the new password failed: %s", w.Body.String())
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	for _, policy := range []string{"reject", "restrict"} {
+		a := newTestApp(t, func(c *config.Config) { c.Auth.Verification = policy })
+		request := func(method, path string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if cookie != nil {
+				r.AddCookie(cookie)
+			}
+
+			w := httptest.NewRecorder()
+			a.ServeHTTP(w, r)
+			return w
+		}
+		signIn := func() *httptest.ResponseRecorder {
+			return request(http.MethodPost, "/oauth", url.Values{"email": {"foo@bar.com"}, "password": {"pass"}}, nil)
+		}
+
+		signUp(a, "foo@bar.com")
+		if w := request(http.MethodPost, "/verify/resend", url.Values{"email": {"foo@bar.com"}}, nil); w.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: got %d want a resend right after sign up throttled", policy, w.Code)
+		}
+
+		w := signIn()
+		switch policy {
+		case "reject":
+			if w.Code != http.StatusForbidden {
+				t.Errorf("reject: got %d %s want an unverified sign in refused", w.Code, w.Body.String())
+			}
+		case "restrict":
+			cookie := w.Result().Cookies()[0]
+			if w := request(http.MethodGet, "/home", nil, cookie); w.Code != http.StatusOK {
+				t.Errorf("restrict: got %d want /home open to unverified users", w.Code)
+			}
+
+			if w := request(http.MethodGet, "/sessions", nil, cookie); w.Code != http.StatusForbidden {
+				t.Errorf("restrict: got %d want /sessions closed to unverified users", w.Code)
+			}
+		}
+
+		messages := a.Mailer.(*mail.MemoryMailer).Messages()
+		if len(messages) != 1 || messages[0].Subject != "Verify your email" {
+			t.Fatalf("%s: got %+v want one verification email", policy, messages)
+		}
+
+		token := strings.Split(messages[0].Body, "\n")[2]
+		if w := request(http.MethodPost, "/verify", url.Values{"token": {token + "x"}}, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d want a tampered token rejected", policy, w.Code)
+		}
+
+		if w := request(http.MethodPost, "/verify", url.Values{"token": {token}}, nil); w.Code != http.StatusOK {
+			t.Fatalf("%s: verifying failed with %d %s", policy, w.Code, w.Body.String())
+		}
+
+		w = signIn()
+		if !strings.Contains(w.Body.String(), `"email_verified":true`) {
+			t.Fatalf("%s: got %s want a verified sign in", policy, w.Body.String())
+		}
+
+		if w := request(http.MethodGet, "/sessions", nil, w.Result().Cookies()[0]); w.Code != http.StatusOK {
+			t.Errorf("%s: got %d want /sessions open once verified", policy, w.Code)
+		}
+	}
+}
+
+// client is a browser of a test app, keeping the last cookie it was sent
+type client struct {
+	a      *app.App
+	cookie *http.Cookie
+}
+
+func (c *client) post(path string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.cookie != nil {
+		r.AddCookie(c.cookie)
+	}
+
+	w := httptest.NewRecorder()
+	c.a.ServeHTTP(w, r)
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		c.cookie = cookies[0]
+	}
+
+	return w
+}
+
+func (c *client) home() int {
+	r := httptest.NewRequest(http.MethodGet, "/home", nil)
+	r.AddCookie(c.cookie)
+	w := httptest.NewRecorder()
+	c.a.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestTOTP(t *testing.T) {
+	a := newTestApp(t)
+	owner := &client{a: a, cookie: signUp(a, "foo@bar.com").Result().Cookies()[0]}
+	var enrolled struct {
+		Data struct {
+			Secret string `json:"secret"`
+			URI    string `json:"uri"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(owner.post("/mfa/totp", nil).Body).Decode(&enrolled); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrolled.Data.Secret)
+	if err != nil || !strings.HasPrefix(enrolled.Data.URI, "otpauth://totp/") {
+		t.Fatalf("got %+v want a base32 secret and its otpauth URI", enrolled.Data)
+	}
+
+	if w := owner.post("/mfa/totp/confirm", url.Values{"code": {"abcdef"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d want a wrong code refused", w.Code)
+	}
+
+	var confirmed struct {
+		Data struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(owner.post("/mfa/totp/confirm", url.Values{"code": {totp.Code(secret, time.Now())}}).Body).Decode(&confirmed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(confirmed.Data.RecoveryCodes) != 10 {
+		t.Fatalf("got %v want 10 recovery codes", confirmed.Data.RecoveryCodes)
+	}
+
+	signIn := func() *client {
+		c := &client{a: a}
+		if w := c.post("/oauth", url.Values{"email": {"foo@bar.com"}, "password": {"pass"}}); !strings.Contains(w.Body.String(), `"mfa_required":true`) {
+			t.Fatalf("got %s want the second factor required", w.Body.String())
+		}
+
+		if code := c.home(); code != http.StatusUnauthorized {
+			t.Fatalf("got %d want a pending sign in kept out", code)
+		}
+
+		return c
+	}
+
+	c := signIn()
+	next := totp.Code(secret, time.Now().Add(totp.Period*time.Second))
+	if w := c.post("/mfa/verify", url.Values{"code": {next}}); !strings.Contains(w.Body.String(), `"mfa_enabled":true`) || c.home() != http.StatusOK {
+		t.Fatalf("got %s want the sign in completed by a code", w.Body.String())
+	}
+
+	c = signIn()
+	if w := c.post("/mfa/verify", url.Values{"code": {next}}); !strings.Contains(w.Body.String(), "Authentication failed") {
+		t.Errorf("got %s want a replayed code refused", w.Body.String())
+	}
+
+	recovery := strings.ToLower(confirmed.Data.RecoveryCodes[0])
+	if w := c.post("/mfa/verify", url.Values{"recovery_code": {recovery}}); c.home() != http.StatusOK {
+		t.Fatalf("got %s want the sign in completed by a recovery code", w.Body.String())
+	}
+
+	c = signIn()
+	if w := c.post("/mfa/verify", url.Values{"recovery_code": {recovery}}); !strings.Contains(w.Body.String(), "Authentication failed") {
+		t.Errorf("got %s want a used recovery code refused", w.Body.String())
+	}
+
+	pending := signIn()
+	if w := owner.post("/mfa/totp/disable", url.Values{"password": {"pass"}}); !strings.Contains(w.Body.String(), `"code":0`) {
+		t.Fatalf("disabling failed: %s", w.Body.String())
+	}
+
+	if w := pending.post("/mfa/verify", url.Values{"code": {totp.Code(nil, time.Now())}}); w.Code != http.StatusUnauthorized {
+		t.Errorf("got %d %s want a pending sign in dropped once TOTP is disabled", w.Code, w.Body.String())
+	}
+
+	for i := 0; i < 5; i++ {
+		c.post("/mfa/verify", url.Values{"code": {"x"}})
+	}
+
+	if w := c.post("/mfa/verify", url.Values{"code": {"x"}}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("got %d want guessing throttled", w.Code)
+	}
+
+	if w := owner.post("/mfa/totp/disable", url.Values{"password": {"pass"}}); !strings.Contains(w.Body.String(), `"code":0`) {
+		t.Fatalf("disabling failed: %s", w.Body.String())
+	}
+
+	c = &client{a: a}
+	if c.post("/oauth", url.Values{"email": {"foo@bar.com"}, "password": {"pass"}}); c.home() != http.StatusOK {
+		t.Errorf("sign in still needs a second factor once disabled")
+	}
+}
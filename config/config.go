@@ -0,0 +1,522 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to the name of every environment variable overriding a config value
+const EnvPrefix = "VERNACULAR_"
+
+// Config is the type of the whole application configuration
+type Config struct {
+	Server   Server   `json:"server" yaml:"server"`
+	Database Database `json:"database" yaml:"database"`
+	Session  Session  `json:"session" yaml:"session"`
+	Mail     Mail     `json:"mail" yaml:"mail"`
+	Auth     Auth     `json:"auth" yaml:"auth"`
+}
+
+// Mail configures how emails are sent. Provider is one of log, smtp or memory.
+type Mail struct {
+	Provider string `json:"provider" yaml:"provider"`
+	// From is the sender address of every email
+	From string `json:"from" yaml:"from"`
+	SMTP SMTP   `json:"smtp" yaml:"smtp"`
+}
+
+// SMTP configures the smtp mail provider
+type SMTP struct {
+	// Addr is the host:port of the SMTP server
+	Addr     string `json:"addr" yaml:"addr"`
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// Auth configures account recovery and email verification
+type Auth struct {
+	// ResetURL is the page emailed password reset links point to, the token is appended as the token query parameter.
+	// When empty the email only holds the token.
+	ResetURL string `json:"reset_url" yaml:"reset_url"`
+	// ResetLifetime is the number of seconds a password reset token is valid
+	ResetLifetime int `json:"reset_lifetime" yaml:"reset_lifetime"`
+	// ResetRequestInterval is the number of seconds before another password reset email is sent to the same address
+	ResetRequestInterval int `json:"reset_request_interval" yaml:"reset_request_interval"`
+	// Verification is how unverified accounts are treated: allow lets them in, restrict keeps them out of routes needing a verified email,
+	// reject refuses to sign them in
+	Verification string `json:"verification" yaml:"verification"`
+	// VerifyURL is the page emailed verification links point to, like ResetURL
+	VerifyURL string `json:"verify_url" yaml:"verify_url"`
+	// VerifyLifetime is the number of seconds a verification token is valid
+	VerifyLifetime int `json:"verify_lifetime" yaml:"verify_lifetime"`
+	// VerifyKey is the base64 encoded HMAC key of at least 32 bytes signing verification tokens.
+	// When empty a random key is used, so tokens do not survive a restart and are not shared between replicas.
+	VerifyKey string `json:"verify_key" yaml:"verify_key"`
+	// VerifyResendInterval is the number of seconds before another verification email is sent to the same address
+	VerifyResendInterval int `json:"verify_resend_interval" yaml:"verify_resend_interval"`
+	// TOTPIssuer names the service in authenticator apps
+	TOTPIssuer string `json:"totp_issuer" yaml:"totp_issuer"`
+}
+
+// DecodeVerifyKey returns the raw HMAC key, nil when none is set
+func (a Auth) DecodeVerifyKey() ([]byte, error) {
+	if a.VerifyKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(a.VerifyKey)
+	if err != nil {
+		return nil, fmt.Errorf("auth.verify_key: %w", err)
+	}
+
+	if len(key) < 32 {
+		return nil, fmt.Errorf("auth.verify_key is %d bytes long, want at least 32", len(key))
+	}
+
+	return key, nil
+}
+
+// Server configures the HTTP server
+type Server struct {
+	Addr string `json:"addr" yaml:"addr"`
+	// LoginURL is where browsers requesting a protected page without signing in are redirected, empty to answer 401 instead
+	LoginURL string `json:"login_url" yaml:"login_url"`
+	// Metrics serves the counters of the session store at /metrics/sessions
+	Metrics bool `json:"metrics" yaml:"metrics"`
+	// ShutdownTimeout is the number of seconds in flight requests are given to finish on SIGINT or SIGTERM
+	ShutdownTimeout int `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+}
+
+// Database configures the user store. Driver is one of mysql, postgres, sqlite or memory.
+type Database struct {
+	Driver   string `json:"driver" yaml:"driver"`
+	Host     string `json:"host" yaml:"host"`
+	Port     int    `json:"port" yaml:"port"`
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+	Name     string `json:"name" yaml:"name"`
+	// Path is the database file used by the sqlite driver
+	Path string `json:"path" yaml:"path"`
+	// Params are extra driver specific connection parameters, e.g. sslmode=disable for postgres
+	Params string `json:"params" yaml:"params"`
+	// AutoMigrate applies pending schema migrations when the server starts
+	AutoMigrate bool `json:"auto_migrate" yaml:"auto_migrate"`
+	// MaxOpenConns and MaxIdleConns bound the connection pool, 0 means unlimited open and the database/sql default idle connections
+	MaxOpenConns int `json:"max_open_conns" yaml:"max_open_conns"`
+	MaxIdleConns int `json:"max_idle_conns" yaml:"max_idle_conns"`
+	// ConnMaxLifetime is the number of seconds a pooled connection may be reused, 0 means forever
+	ConnMaxLifetime int `json:"conn_max_lifetime" yaml:"conn_max_lifetime"`
+	// PingTimeout is the number of seconds the startup health check waits for the database
+	PingTimeout int `json:"ping_timeout" yaml:"ping_timeout"`
+}
+
+// Session configures the session manager
+type Session struct {
+	Provider   string `json:"provider" yaml:"provider"`
+	CookieName string `json:"cookie_name" yaml:"cookie_name"`
+	// MaxLifetime is the idle timeout in seconds, a session not used for that long expires
+	MaxLifetime int `json:"max_lifetime" yaml:"max_lifetime"`
+	// AbsoluteLifetime is the number of seconds after sign in a session expires however active, 0 means never
+	AbsoluteLifetime int `json:"absolute_lifetime" yaml:"absolute_lifetime"`
+	// RefreshCookie renews the cookie on activity so it expires along with the session
+	RefreshCookie bool `json:"refresh_cookie" yaml:"refresh_cookie"`
+	// Codec serializes session values stored outside the process, gob or json
+	Codec  string `json:"codec" yaml:"codec"`
+	Memory Memory `json:"memory" yaml:"memory"`
+	Redis  Redis  `json:"redis" yaml:"redis"`
+	File   File   `json:"file" yaml:"file"`
+	Cookie Cookie `json:"cookie" yaml:"cookie"`
+}
+
+// Cookie configures the cookie session provider
+type Cookie struct {
+	// Keys are base64 encoded AES keys of 16, 24 or 32 bytes. The first seals new sessions, all of them open existing ones.
+	Keys []string `json:"keys" yaml:"keys"`
+}
+
+// DecodeKeys returns the raw AES keys
+func (c Cookie) DecodeKeys() ([][]byte, error) {
+	keys := make([][]byte, 0, len(c.Keys))
+	for i, k := range c.Keys {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("session.cookie.keys[%d]: %w", i, err)
+		}
+
+		if n := len(key); n != 16 && n != 24 && n != 32 {
+			return nil, fmt.Errorf("session.cookie.keys[%d] is %d bytes long, want 16, 24 or 32", i, n)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Memory configures the memory session provider. Limits of 0 mean unlimited.
+type Memory struct {
+	// MaxSessions is the number of sessions kept, the least recently used are evicted past it
+	MaxSessions int `json:"max_sessions" yaml:"max_sessions"`
+	// MaxSessionBytes bounds the encoded size of the values of a session
+	MaxSessionBytes int `json:"max_session_bytes" yaml:"max_session_bytes"`
+}
+
+// File configures the file session provider
+type File struct {
+	// Dir is the directory holding one file per session
+	Dir string `json:"dir" yaml:"dir"`
+}
+
+// Redis configures the connection of the redis session provider
+type Redis struct {
+	Addr     string `json:"addr" yaml:"addr"`
+	Password string `json:"password" yaml:"password"`
+	DB       int    `json:"db" yaml:"db"`
+	// Prefix is prepended to every session id to form its Redis key
+	Prefix string `json:"prefix" yaml:"prefix"`
+}
+
+// Default returns the configuration used when no file or environment variable overrides a value
+func Default() *Config {
+	return &Config{
+		Server: Server{Addr: ":8080", ShutdownTimeout: 15},
+		Database: Database{
+			Driver:          "mysql",
+			Host:            "localhost",
+			Port:            3306,
+			User:            "root",
+			Password:        "mysql",
+			Name:            "basic",
+			Path:            "vernacular.db",
+			AutoMigrate:     true,
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 300,
+			PingTimeout:     5,
+		},
+		Session: Session{
+			Provider:      "memory",
+			CookieName:    "gosessionid",
+			MaxLifetime:   3600,
+			RefreshCookie: true,
+			Codec:         "gob",
+			Redis: Redis{
+				Addr:   "localhost:6379",
+				Prefix: "session:",
+			},
+			File: File{Dir: "sessions"},
+		},
+		Mail: Mail{Provider: "log", From: "no-reply@localhost"},
+		Auth: Auth{
+			ResetLifetime:        3600,
+			ResetRequestInterval: 60,
+			Verification:         "allow",
+			VerifyLifetime:       86400,
+			VerifyResendInterval: 60,
+			TOTPIssuer:           "vernacular-auth",
+		},
+	}
+}
+
+// Load returns the default configuration overridden by the file at path, when path is not empty, and then by environment variables.
+// The result is validated before being returned.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.readEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// readFile decodes the JSON or YAML file at path, chosen by its extension, over c
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, c)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("config: unsupported file extension %q", ext)
+	}
+
+	if err != nil {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// readEnv overrides c with the environment variables returned by lookup
+func (c *Config) readEnv(lookup func(string) (string, bool)) error {
+	strs := map[string]*string{
+		"SERVER_ADDR":         &c.Server.Addr,
+		"SERVER_LOGIN_URL":    &c.Server.LoginURL,
+		"DB_DRIVER":           &c.Database.Driver,
+		"DB_HOST":             &c.Database.Host,
+		"DB_USER":             &c.Database.User,
+		"DB_PASSWORD":         &c.Database.Password,
+		"DB_NAME":             &c.Database.Name,
+		"DB_PATH":             &c.Database.Path,
+		"DB_PARAMS":           &c.Database.Params,
+		"SESSION_PROVIDER":    &c.Session.Provider,
+		"SESSION_COOKIE_NAME": &c.Session.CookieName,
+		"REDIS_ADDR":          &c.Session.Redis.Addr,
+		"REDIS_PASSWORD":      &c.Session.Redis.Password,
+		"REDIS_PREFIX":        &c.Session.Redis.Prefix,
+		"SESSION_FILE_DIR":    &c.Session.File.Dir,
+		"SESSION_CODEC":       &c.Session.Codec,
+		"MAIL_PROVIDER":       &c.Mail.Provider,
+		"MAIL_FROM":           &c.Mail.From,
+		"SMTP_ADDR":           &c.Mail.SMTP.Addr,
+		"SMTP_USERNAME":       &c.Mail.SMTP.Username,
+		"SMTP_PASSWORD":       &c.Mail.SMTP.Password,
+		"AUTH_RESET_URL":      &c.Auth.ResetURL,
+		"AUTH_VERIFICATION":   &c.Auth.Verification,
+		"AUTH_VERIFY_URL":     &c.Auth.VerifyURL,
+		"AUTH_VERIFY_KEY":     &c.Auth.VerifyKey,
+		"AUTH_TOTP_ISSUER":    &c.Auth.TOTPIssuer,
+	}
+	for name, p := range strs {
+		if v, ok := lookup(EnvPrefix + name); ok {
+			*p = v
+		}
+	}
+
+	ints := map[string]*int{
+		"SERVER_SHUTDOWN_TIMEOUT":          &c.Server.ShutdownTimeout,
+		"DB_PORT":                          &c.Database.Port,
+		"DB_MAX_OPEN_CONNS":                &c.Database.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS":                &c.Database.MaxIdleConns,
+		"DB_CONN_MAX_LIFETIME":             &c.Database.ConnMaxLifetime,
+		"DB_PING_TIMEOUT":                  &c.Database.PingTimeout,
+		"SESSION_MAX_LIFETIME":             &c.Session.MaxLifetime,
+		"SESSION_ABSOLUTE_LIFETIME":        &c.Session.AbsoluteLifetime,
+		"REDIS_DB":                         &c.Session.Redis.DB,
+		"SESSION_MEMORY_MAX_SESSIONS":      &c.Session.Memory.MaxSessions,
+		"SESSION_MEMORY_MAX_SESSION_BYTES": &c.Session.Memory.MaxSessionBytes,
+		"AUTH_RESET_LIFETIME":              &c.Auth.ResetLifetime,
+		"AUTH_RESET_REQUEST_INTERVAL":      &c.Auth.ResetRequestInterval,
+		"AUTH_VERIFY_LIFETIME":             &c.Auth.VerifyLifetime,
+		"AUTH_VERIFY_RESEND_INTERVAL":      &c.Auth.VerifyResendInterval,
+	}
+	for name, p := range ints {
+		if v, ok := lookup(EnvPrefix + name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("config: %s%s: %w", EnvPrefix, name, err)
+			}
+
+			*p = n
+		}
+	}
+
+	lists := map[string]*[]string{
+		"SESSION_COOKIE_KEYS": &c.Session.Cookie.Keys,
+	}
+	for name, p := range lists {
+		if v, ok := lookup(EnvPrefix + name); ok {
+			*p = strings.Split(v, ",")
+		}
+	}
+
+	bools := map[string]*bool{
+		"DB_AUTO_MIGRATE":        &c.Database.AutoMigrate,
+		"SESSION_REFRESH_COOKIE": &c.Session.RefreshCookie,
+		"SERVER_METRICS":         &c.Server.Metrics,
+	}
+	for name, p := range bools {
+		if v, ok := lookup(EnvPrefix + name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("config: %s%s: %w", EnvPrefix, name, err)
+			}
+
+			*p = b
+		}
+	}
+
+	return nil
+}
+
+// Validate reports every invalid value of c
+func (c *Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr: %w", err))
+	}
+
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout must be positive"))
+	}
+
+	switch c.Database.Driver {
+	case "mysql", "postgres":
+		if c.Database.Host == "" {
+			errs = append(errs, errors.New("database.host is required"))
+		}
+
+		if c.Database.Port <= 0 || c.Database.Port > 65535 {
+			errs = append(errs, fmt.Errorf("database.port %d is out of range", c.Database.Port))
+		}
+
+		if c.Database.Name == "" {
+			errs = append(errs, errors.New("database.name is required"))
+		}
+	case "sqlite":
+		if c.Database.Path == "" {
+			errs = append(errs, errors.New("database.path is required for sqlite"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("database.driver %q is not one of mysql, postgres, sqlite or memory", c.Database.Driver))
+	}
+
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 || c.Database.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database pool sizes and lifetime must not be negative"))
+	}
+
+	if c.Database.PingTimeout <= 0 {
+		errs = append(errs, errors.New("database.ping_timeout must be positive"))
+	}
+
+	if c.Session.Provider == "" {
+		errs = append(errs, errors.New("session.provider is required"))
+	}
+
+	if c.Session.CookieName == "" || strings.ContainsAny(c.Session.CookieName, " \t\r\n;,=\"") {
+		errs = append(errs, fmt.Errorf("session.cookie_name %q is not a valid cookie name", c.Session.CookieName))
+	}
+
+	if c.Session.MaxLifetime <= 0 {
+		errs = append(errs, errors.New("session.max_lifetime must be positive"))
+	}
+
+	if c.Session.Codec != "gob" && c.Session.Codec != "json" {
+		errs = append(errs, fmt.Errorf("session.codec %q is not one of gob or json", c.Session.Codec))
+	}
+
+	if c.Session.Memory.MaxSessions < 0 || c.Session.Memory.MaxSessionBytes < 0 {
+		errs = append(errs, errors.New("session.memory limits must not be negative"))
+	}
+
+	if c.Session.AbsoluteLifetime < 0 {
+		errs = append(errs, errors.New("session.absolute_lifetime must not be negative"))
+	}
+
+	if c.Session.Provider == "sql" && c.Database.Driver == "memory" {
+		errs = append(errs, errors.New("the sql session provider needs database.driver to be an SQL database"))
+	}
+
+	if c.Session.Provider == "redis" && c.Session.Redis.Addr == "" {
+		errs = append(errs, errors.New("session.redis.addr is required for the redis provider"))
+	}
+
+	if c.Session.Provider == "cookie" {
+		if len(c.Session.Cookie.Keys) == 0 {
+			errs = append(errs, errors.New("session.cookie.keys needs at least one key for the cookie provider"))
+		} else if _, err := c.Session.Cookie.DecodeKeys(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.Session.Provider == "file" && c.Session.File.Dir == "" {
+		errs = append(errs, errors.New("session.file.dir is required for the file provider"))
+	}
+
+	switch c.Mail.Provider {
+	case "log", "memory":
+	case "smtp":
+		if _, _, err := net.SplitHostPort(c.Mail.SMTP.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("mail.smtp.addr: %w", err))
+		}
+
+		if c.Mail.From == "" {
+			errs = append(errs, errors.New("mail.from is required for the smtp provider"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("mail.provider %q is not one of log, smtp or memory", c.Mail.Provider))
+	}
+
+	if c.Auth.ResetLifetime <= 0 || c.Auth.ResetRequestInterval < 0 {
+		errs = append(errs, errors.New("auth.reset_lifetime must be positive and auth.reset_request_interval not negative"))
+	}
+
+	if c.Auth.Verification != "allow" && c.Auth.Verification != "restrict" && c.Auth.Verification != "reject" {
+		errs = append(errs, fmt.Errorf("auth.verification %q is not one of allow, restrict or reject", c.Auth.Verification))
+	}
+
+	if c.Auth.VerifyLifetime <= 0 || c.Auth.VerifyResendInterval < 0 {
+		errs = append(errs, errors.New("auth.verify_lifetime must be positive and auth.verify_resend_interval not negative"))
+	}
+
+	if _, err := c.Auth.DecodeVerifyKey(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Auth.TOTPIssuer == "" || strings.Contains(c.Auth.TOTPIssuer, ":") {
+		errs = append(errs, fmt.Errorf("auth.totp_issuer %q must be set and hold no colon", c.Auth.TOTPIssuer))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: invalid configuration: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// DSN returns the data source name passed to sql.Open for the configured driver
+func (d Database) DSN() string {
+	switch d.Driver {
+	case "mysql":
+		dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&clientFoundRows=true", d.User, d.Password, net.JoinHostPort(d.Host, strconv.Itoa(d.Port)), d.Name)
+		if d.Params != "" {
+			dsn += "&" + d.Params
+		}
+
+		return dsn
+	case "postgres":
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(d.User, d.Password),
+			Host:     net.JoinHostPort(d.Host, strconv.Itoa(d.Port)),
+			Path:     "/" + d.Name,
+			RawQuery: d.Params,
+		}
+		return u.String()
+	case "sqlite":
+		dsn := "file:" + d.Path + "?_foreign_keys=on&_busy_timeout=5000"
+		if d.Params != "" {
+			dsn += "&" + d.Params
+		}
+
+		return dsn
+	}
+
+	return ""
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter lets each key act a number of times per window. It is kept in process memory, so every replica counts on its own.
+type limiter struct {
+	lock   sync.Mutex
+	max    int
+	window time.Duration
+	events map[string][]time.Time
+	swept  time.Time
+}
+
+// newLimiter returns a limiter allowing max actions per window and key
+func newLimiter(max int, window time.Duration) *limiter {
+	return &limiter{max: max, window: window, events: make(map[string][]time.Time)}
+}
+
+// allow reports whether key may act at now and records it when so.
+// Only the actions of key are pruned on each call. Keys seen once and never again are swept at most once per window, so the map stays bounded without walking it on every call.
+func (l *limiter) allow(key string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if now.Sub(l.swept) >= l.window {
+		for k := range l.events {
+			l.prune(k, now)
+		}
+
+		l.swept = now
+	}
+
+	times := l.prune(key, now)
+	if len(times) >= l.max {
+		return false
+	}
+
+	l.events[key] = append(times, now)
+	return true
+}
+
+// prune forgets the actions of key older than the window and returns the rest. The caller holds l.lock.
+func (l *limiter) prune(key string, now time.Time) []time.Time {
+	times := l.events[key]
+	kept := times[:0]
+	for _, at := range times {
+		if now.Sub(at) < l.window {
+			kept = append(kept, at)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(l.events, key)
+		return nil
+	}
+
+	l.events[key] = kept
+	return kept
+}
@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l, now := newLimiter(2, time.Minute), time.Now()
+	if !l.allow("a", now) || !l.allow("a", now.Add(time.Second)) || l.allow("a", now.Add(2*time.Second)) {
+		t.Errorf("want two actions allowed and the third within the window refused")
+	}
+
+	if !l.allow("b", now) {
+		t.Errorf("keys were not limited independently")
+	}
+
+	if !l.allow("a", now.Add(time.Minute)) {
+		t.Errorf("action once the first left the window was refused")
+	}
+
+	l.allow("c", now.Add(3*time.Minute))
+	if len(l.events) != 1 {
+		t.Errorf("got %d keys want the stale ones swept", len(l.events))
+	}
+}
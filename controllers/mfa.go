@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/middleware"
+	"github.com/vabshere/vernacular-auth/models"
+	"github.com/vabshere/vernacular-auth/utils"
+	"github.com/vabshere/vernacular-auth/utils/totp"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxMFAAttempts is the number of second factor codes a user may try within middleware.PendingLifetime
+const maxMFAAttempts = 5
+
+// recoveryCodeCount is the number of recovery codes handed out when TOTP is enabled
+const recoveryCodeCount = 10
+
+// EnrollTOTP generates a new TOTP secret for the signed in user and returns it along with its otpauth:// URI.
+// TOTP is only enabled once ConfirmTOTP receives a code of the secret.
+func (c *Controller) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := c.currentUser(r)
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if user.TOTPEnabled {
+		utils.Respond(1, "Two-factor authentication already enabled", http.StatusConflict, w, r)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := c.Users.SetTOTP(user.Id, secret, false); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	data := map[string]string{"secret": totp.EncodeSecret(secret), "uri": totp.URI(c.Config.Auth.TOTPIssuer, user.Email, secret)}
+	utils.RespondJson(0, data, http.StatusOK, w, r)
+	return
+}
+
+// ConfirmTOTP enables TOTP for the signed in user when the submitted code matches the secret of EnrollTOTP.
+// It returns recovery codes, each usable once in place of a code. They are shown only this once.
+func (c *Controller) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	user, err := c.currentUser(r)
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if user.TOTPEnabled {
+		utils.Respond(1, "Two-factor authentication already enabled", http.StatusConflict, w, r)
+		return
+	}
+
+	if user.TOTPSecret == nil {
+		utils.Respond(1, "No enrollment pending", http.StatusBadRequest, w, r)
+		return
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, r.FormValue("code"), time.Now())
+	if !ok {
+		utils.Respond(1, "Invalid code", http.StatusBadRequest, w, r)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := c.Users.SetRecoveryCodes(user.Id, hashes); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := c.Users.UseTOTPStep(user.Id, step); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	// enabled last, so a failure above leaves TOTP off rather than on without recovery codes
+	if err := c.Users.SetTOTP(user.Id, user.TOTPSecret, true); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	utils.RespondJson(0, map[string][]string{"recovery_codes": codes}, http.StatusOK, w, r)
+	return
+}
+
+// DisableTOTP turns TOTP off for the signed in user after checking their password, and drops their recovery codes
+func (c *Controller) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	user, err := c.currentUser(r)
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	password := []byte(template.HTMLEscapeString(r.FormValue("password")))
+	if err := bcrypt.CompareHashAndPassword(user.Password, password); err != nil {
+		utils.Respond(1, "Authentication failed", http.StatusOK, w, r)
+		return
+	}
+
+	if err := c.Users.SetTOTP(user.Id, nil, false); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := c.Users.SetRecoveryCodes(user.Id, nil); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	utils.Respond(0, "Success", http.StatusOK, w, r)
+	return
+}
+
+// VerifyMFA checks the submitted TOTP code, or recovery_code, of the sign in waiting for its second factor.
+// Returns a pointer to the user on success so SecondFactor completes the sign in, nil otherwise.
+func (c *Controller) VerifyMFA(w http.ResponseWriter, r *http.Request) *models.User {
+	r.ParseForm()
+	userId, ok := middleware.PendingUser(r)
+	if !ok {
+		utils.Respond(1, "No sign in pending", http.StatusUnauthorized, w, r)
+		return nil
+	}
+
+	now := time.Now()
+	if !c.mfaAttempts.allow(strconv.Itoa(userId), now) {
+		utils.Respond(1, "Too many requests", http.StatusTooManyRequests, w, r)
+		return nil
+	}
+
+	user, err := c.Users.GetUserById(userId)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return nil
+	}
+
+	// TOTP disabled since the password was checked, any code of the empty secret would pass
+	if err != nil || !user.TOTPEnabled || user.TOTPSecret == nil {
+		utils.Respond(1, "No sign in pending", http.StatusUnauthorized, w, r)
+		return nil
+	}
+
+	if code := r.FormValue("recovery_code"); code != "" {
+		err = c.Users.UseRecoveryCode(user.Id, hashToken(normalizeRecoveryCode(code)))
+	} else if step, ok := totp.Validate(user.TOTPSecret, r.FormValue("code"), now); ok {
+		err = c.Users.UseTOTPStep(user.Id, step)
+	} else {
+		err = models.ErrCodeUsed
+	}
+
+	if errors.Is(err, models.ErrCodeUsed) {
+		utils.Respond(1, "Authentication failed", http.StatusOK, w, r)
+		return nil
+	}
+
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return nil
+	}
+
+	return user
+}
+
+// currentUser returns the signed in user of r as stored, secrets included
+func (c *Controller) currentUser(r *http.Request) (*models.User, error) {
+	user, _ := middleware.FromContext(r.Context())
+	return c.Users.GetUserById(user.Id)
+}
+
+// newRecoveryCodes returns recoveryCodeCount random recovery codes, formatted XXXX-XXXX-XXXX-XXXX, along with the hashes stored in their place
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		raw := base32.StdEncoding.EncodeToString(b)
+		codes = append(codes, raw[0:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:16])
+		hashes = append(hashes, hashToken(raw))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode undoes the formatting of a recovery code typed by a user
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/mail"
+	"github.com/vabshere/vernacular-auth/models"
+	"github.com/vabshere/vernacular-auth/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RequestPasswordReset emails a single use password reset token to the user with the submitted email, at most once per auth.reset_request_interval.
+// It answers the same, and as fast, whether or not the email belongs to a user, so accounts cannot be discovered through it.
+func (c *Controller) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	email := template.HTMLEscapeString(r.FormValue("email"))
+	if len(email) == 0 {
+		utils.Respond(1, "Invalid submission", http.StatusBadRequest, w, r)
+		return
+	}
+
+	if !c.resets.allow(email, time.Now()) {
+		utils.Respond(1, "Too many requests", http.StatusTooManyRequests, w, r)
+		return
+	}
+
+	user, err := c.Users.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	// the token is stored and mailed after answering, which would otherwise take longer for users than for unknown emails
+	if err == nil {
+		c.Go(func(ctx context.Context) { c.sendReset(ctx, user) })
+	}
+
+	utils.Respond(0, "Success", http.StatusOK, w, r)
+	return
+}
+
+// sendReset stores a new reset token of user and emails it. Failures are logged, as the request was already answered.
+func (c *Controller) sendReset(ctx context.Context, user *models.User) {
+	token, hash, err := newResetToken()
+	if err != nil {
+		c.Logger.Printf("creating password reset token: %v", err)
+		return
+	}
+
+	lifetime := time.Duration(c.Config.Auth.ResetLifetime) * time.Second
+	if err := c.Users.CreateResetToken(user.Id, hash, time.Now().Add(lifetime).Unix()); err != nil {
+		c.Logger.Printf("storing password reset token: %v", err)
+		return
+	}
+
+	msg := mail.Message{To: user.Email, Subject: "Reset your password", Body: c.resetBody(token, lifetime)}
+	if err := c.Mailer.Send(ctx, msg); err != nil {
+		c.Logger.Printf("sending password reset email: %v", err)
+	}
+}
+
+// ConfirmPasswordReset sets the submitted new password of the user a reset token was emailed to.
+// The token is consumed, every session of the user is signed out and their email counts as verified.
+func (c *Controller) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	token := r.FormValue("token")
+	newPassword := []byte(template.HTMLEscapeString(r.FormValue("new_password")))
+	if len(token) == 0 || len(newPassword) == 0 {
+		utils.Respond(1, "Invalid submission", http.StatusBadRequest, w, r)
+		return
+	}
+
+	userId, err := c.Users.ConsumeResetToken(hashToken(token))
+	if errors.Is(err, models.ErrTokenNotFound) {
+		utils.Respond(1, "Invalid or expired token", http.StatusBadRequest, w, r)
+		return
+	}
+
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	user, err := c.Users.GetUserById(userId)
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := c.setPassword(user, newPassword); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	// the token was read from the inbox of the user, which proves they own the email
+	if !user.EmailVerified {
+		if err := c.Users.SetEmailVerified(user.Id); err != nil {
+			utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+			return
+		}
+	}
+
+	utils.Respond(0, "Success", http.StatusOK, w, r)
+	return
+}
+
+// setPassword stores the bcrypt hash of newPassword as the password of user and signs out every session of the user
+func (c *Controller) setPassword(user *models.User, newPassword []byte) error {
+	hash, err := bcrypt.GenerateFromPassword(newPassword, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	user.Password = hash
+	if err := c.Users.UpdateUser(user); err != nil {
+		return err
+	}
+
+	if err := c.Users.IncrementSessionGeneration(user.Id); err != nil {
+		return err
+	}
+
+	return c.SessionIndex.DeleteUserSessions(user.Id, "")
+}
+
+// resetBody returns the text of the password reset email carrying token
+func (c *Controller) resetBody(token string, lifetime time.Duration) string {
+	return fmt.Sprintf("A password reset was requested for your account. Use this to choose a new password:\n\n%s\n\nIt expires in %s. If you did not ask for it, ignore this email.\n",
+		tokenLink(c.Config.Auth.ResetURL, token), lifetime)
+}
+
+// newResetToken returns a random token along with the hash stored in its place
+func newResetToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex SHA-256 of token. Tokens are random, so a fast hash is enough to keep them useless to whoever reads the store.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/middleware"
+	"github.com/vabshere/vernacular-auth/models"
+	"github.com/vabshere/vernacular-auth/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// ListSessions returns the active sessions of the signed in user, most recently seen first, with the one of the request marked current
+func (c *Controller) ListSessions(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.FromContext(r.Context())
+	policy := c.Sessions.Policy()
+	now := time.Now().Unix()
+	if err := middleware.PruneIdle(c.SessionIndex, policy, user.Id, now); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	all, err := c.SessionIndex.ListSessions(user.Id)
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	current := middleware.HandleFromContext(r.Context())
+	sessions := make([]*models.UserSession, 0, len(all))
+	for _, s := range all {
+		if policy.AbsoluteLifetime > 0 && now-s.Created >= int64(policy.AbsoluteLifetime) {
+			continue
+		}
+
+		s.Current = s.Handle == current
+		sessions = append(sessions, s)
+	}
+
+	utils.RespondJson(0, sessions, http.StatusOK, w, r)
+	return
+}
+
+// RevokeSession signs the user out of the session with the id given in the path. Revoking the current session signs out the request too.
+func (c *Controller) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.FromContext(r.Context())
+	handle := mux.Vars(r)["id"]
+	s, err := c.SessionIndex.GetSession(handle)
+	if errors.Is(err, models.ErrSessionNotFound) || (err == nil && s.UserId != user.Id) {
+		utils.Respond(1, "Session not found", http.StatusNotFound, w, r)
+		return
+	}
+
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := c.SessionIndex.DeleteSession(handle); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if handle == middleware.HandleFromContext(r.Context()) {
+		if err := c.Sessions.SessionDestroy(w, r); err != nil {
+			utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+			return
+		}
+	}
+
+	utils.Respond(0, "Success", http.StatusOK, w, r)
+	return
+}
+
+// RevokeOtherSessions signs the user out of every session but the one of the request
+func (c *Controller) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.FromContext(r.Context())
+	if err := c.SessionIndex.DeleteUserSessions(user.Id, middleware.HandleFromContext(r.Context())); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	utils.Respond(0, "Success", http.StatusOK, w, r)
+	return
+}
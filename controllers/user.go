@@ -1,11 +1,15 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"regexp"
 	"text/template"
+	"time"
 
+	"github.com/vabshere/vernacular-auth/app"
+	"github.com/vabshere/vernacular-auth/middleware"
 	"github.com/vabshere/vernacular-auth/models"
 	"github.com/vabshere/vernacular-auth/utils"
 
@@ -23,10 +27,30 @@ func newUser(name, email, password string) models.User {
 	return u
 }
 
-var saveUser = models.SaveUser
+// Controller serves the user routes of an App
+type Controller struct {
+	*app.App
+	// resends limits the verification emails sent to each address
+	resends *limiter
+	// resets limits the password reset emails sent to each address
+	resets *limiter
+	// mfaAttempts limits the second factor codes tried for each user
+	mfaAttempts *limiter
+}
+
+// New returns the Controller of a
+func New(a *app.App) *Controller {
+	return &Controller{
+		App:         a,
+		resends:     newLimiter(1, time.Duration(a.Config.Auth.VerifyResendInterval)*time.Second),
+		resets:      newLimiter(1, time.Duration(a.Config.Auth.ResetRequestInterval)*time.Second),
+		mfaAttempts: newLimiter(maxMFAAttempts, middleware.PendingLifetime*time.Second),
+	}
+}
 
-// SignUp creates a new user in the database and creates its session. Returns a pointer to user instance on success, nil otherwise.
-func SignUp(w http.ResponseWriter, r *http.Request) *models.User {
+// SignUp creates a new user in the database, emails it a verification token and creates its session, unless auth.verification is reject.
+// Returns a pointer to user instance on success, nil otherwise.
+func (c *Controller) SignUp(w http.ResponseWriter, r *http.Request) *models.User {
 	r.ParseForm()
 	u := newUser(r.FormValue("name"), r.FormValue("email"), r.FormValue("password"))
 
@@ -47,25 +71,29 @@ func SignUp(w http.ResponseWriter, r *http.Request) *models.User {
 	}
 
 	u.Password = hash
-	err = saveUser(&u)
-	if err != nil {
-		s := string(err.Error())
-		if s[len("Error "):len("Error 1062")] == "1062" {
-			utils.Respond(1, "Email already taken", http.StatusOK, w, r)
-			return nil
-		}
+	err = c.Users.CreateUser(&u)
+	if errors.Is(err, models.ErrEmailTaken) {
+		utils.Respond(1, "Email already taken", http.StatusOK, w, r)
+		return nil
+	}
 
+	if err != nil {
 		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
 		return nil
 	}
 
+	c.resends.allow(u.Email, time.Now())
+	c.sendVerification(r.Context(), &u)
+	if c.Config.Auth.Verification == "reject" {
+		utils.Respond(0, "Verify your email to sign in", http.StatusOK, w, r)
+		return nil
+	}
+
 	return &u
 }
 
-var getUserByEmail = models.GetUserByEmail
-
 // SignIn checks if the user exists in the database and creates a session on successful attempt. Returns a pointer to user instance on success, nil otherwise
-func SignIn(w http.ResponseWriter, r *http.Request) *models.User {
+func (c *Controller) SignIn(w http.ResponseWriter, r *http.Request) *models.User {
 	r.ParseForm()
 	u := newUser("", r.FormValue("email"), r.FormValue("password"))
 	if len(u.Email) == 0 || len(u.Password) == 0 {
@@ -78,7 +106,12 @@ func SignIn(w http.ResponseWriter, r *http.Request) *models.User {
 		return nil
 	}
 
-	user, err := getUserByEmail(u.Email)
+	user, err := c.Users.GetUserByEmail(u.Email)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.Respond(1, "Authentication failed", http.StatusOK, w, r)
+		return nil
+	}
+
 	if err != nil {
 		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
 		return nil
@@ -89,29 +122,90 @@ func SignIn(w http.ResponseWriter, r *http.Request) *models.User {
 		return nil
 	}
 
+	if !user.EmailVerified && c.Config.Auth.Verification == "reject" {
+		utils.Respond(1, "Email not verified", http.StatusForbidden, w, r)
+		return nil
+	}
+
+	return user
+}
+
+// ChangePassword replaces the password of the signed in user after checking the current one.
+// Every other session of the user is signed out by bumping its session generation, the current one is kept by SessionReset.
+func (c *Controller) ChangePassword(w http.ResponseWriter, r *http.Request) *models.User {
+	r.ParseForm()
+	current, ok := middleware.FromContext(r.Context())
+	if !ok {
+		utils.Respond(1, "Unauthorized", http.StatusUnauthorized, w, r)
+		return nil
+	}
+
+	password := []byte(template.HTMLEscapeString(r.FormValue("password")))
+	newPassword := []byte(template.HTMLEscapeString(r.FormValue("new_password")))
+	if len(password) == 0 || len(newPassword) == 0 {
+		utils.Respond(1, "Invalid submission", http.StatusBadRequest, w, r)
+		return nil
+	}
+
+	user, err := c.Users.GetUserById(current.Id)
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword(user.Password, password); err != nil {
+		utils.Respond(1, "Authentication failed", http.StatusOK, w, r)
+		return nil
+	}
+
+	if err := c.setPassword(user, newPassword); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return nil
+	}
+
+	user, err = c.Users.GetUserById(user.Id)
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return nil
+	}
+
 	return user
 }
 
 // GetUser returns user from the session
-func GetUser(w http.ResponseWriter, r *http.Request) {
-	session, b := utils.GlobalSessions.SessionCheck(r)
-	if b {
-		u := utils.SessionGetUser(&session, r)
-		utils.RespondJson(0, u, http.StatusOK, w, r)
+func (c *Controller) GetUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := middleware.FromContext(r.Context())
+	if !ok {
+		utils.RespondJson(1, nil, http.StatusOK, w, r)
 		return
 	}
 
-	utils.RespondJson(1, nil, http.StatusOK, w, r)
+	utils.RespondJson(0, u, http.StatusOK, w, r)
 	return
 }
 
 // SignOut deletes the user session
-func SignOut(w http.ResponseWriter, r *http.Request) {
-	utils.GlobalSessions.SessionDestroy(w, r)
+func (c *Controller) SignOut(w http.ResponseWriter, r *http.Request) {
+	if err := c.SessionIndex.DeleteSession(middleware.HandleFromContext(r.Context())); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := c.Sessions.SessionDestroy(w, r); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
 	utils.Respond(0, "Success", http.StatusOK, w, r)
 	return
 }
 
+// SessionStats returns the counters of the session store for monitoring
+func (c *Controller) SessionStats(w http.ResponseWriter, r *http.Request) {
+	utils.RespondJson(0, c.Sessions.Stats(), http.StatusOK, w, r)
+	return
+}
+
 // exists returns whether the given path (file or directory) exists
 func exists(path string) (bool, error) {
 	_, err := os.Stat(path)
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -9,7 +10,11 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/vabshere/vernacular-auth/app"
+	"github.com/vabshere/vernacular-auth/config"
+	"github.com/vabshere/vernacular-auth/mail"
 	"github.com/vabshere/vernacular-auth/models"
+	"github.com/vabshere/vernacular-auth/utils"
 )
 
 type mockSignUpReq struct {
@@ -25,20 +30,17 @@ type ResponseStruct struct {
 	Data interface{} `json:"data"`
 }
 
-func mockSaveUser(u *models.User) error {
-	u.Id = 100000
-	return nil
+// newTestController returns a Controller of an App keeping users in an empty in-memory store
+func newTestController() (*Controller, *models.MemoryStore) {
+	store := models.NewMemoryStore()
+	return New(&app.App{Config: config.Default(), Users: store, Mailer: &mail.MemoryMailer{}, Logger: log.Default()}), store
 }
 
 const defaultPass = "pass"
 
 func TestSignUp(t *testing.T) {
-	oldSaveUser := saveUser
-	defer func() {
-		saveUser = oldSaveUser
-	}()
+	c, _ := newTestController()
 
-	saveUser = mockSaveUser
 	mockSignUpRequests := []mockSignUpReq{
 		// {"", "abc@adb.ab", defaultPass, 1, http.StatusBadRequest},                  // empty name
 		// {"foo", "", defaultPass, 1, http.StatusBadRequest},                         // empty email
@@ -65,7 +67,7 @@ func TestSignUp(t *testing.T) {
 		rr := httptest.NewRecorder()
 		var user *models.User
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			user = SignUp(w, r)
+			user = c.SignUp(w, r)
 		})
 		handler.ServeHTTP(rr, req)
 		correctStatusFlag := true
@@ -96,6 +98,39 @@ func TestSignUp(t *testing.T) {
 	}
 }
 
+func TestSignUpEmailTaken(t *testing.T) {
+	c, store := newTestController()
+
+	existing := models.User{Name: "foo", Email: "abc.hj@dgd.dd", Password: []byte(defaultPass)}
+	if err := store.CreateUser(&existing); err != nil {
+		t.Fatal(err)
+	}
+
+	reqBody := url.Values{}
+	reqBody.Set("name", "bar")
+	reqBody.Add("password", defaultPass)
+	reqBody.Add("email", existing.Email)
+	req, err := http.NewRequest(http.MethodPost, "/reg", strings.NewReader(reqBody.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	if user := c.SignUp(rr, req); user != nil {
+		t.Errorf("SignUp returned a user for a taken email")
+	}
+
+	var response utils.Response
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Code != 1 || response.Message != "Email already taken" {
+		t.Errorf("got %+v want the email taken response", response)
+	}
+}
+
 type mockSignInReq struct {
 	email,
 	password string
@@ -114,22 +149,23 @@ var mockSignInRequests = []mockSignInReq{
 	{"abc@adb.abc", defaultPass, 0, http.StatusOK}, // valid input
 }
 
-func mockGetUserByEmail(e string) (*models.User, error) {
-	var u *models.User
+// seedSignInUsers stores a user for every distinct email of mockSignInRequests
+func seedSignInUsers(t *testing.T, store models.UserStore) {
 	for _, mockRequest := range mockSignInRequests {
-		if mockRequest.email == e {
-			return &models.User{Name: "abc", Email: mockRequest.email, Password: []byte("$2a$10$4yRvhHitq43PspRFg1wDEewAcurn1tA3H/Njo067YP9yRKjVU5sae"), Id: 100000}, nil // $2a$10$4yRvhHitq43PspRFg1wDEewAcurn1tA3H/Njo067YP9yRKjVU5sae is bcrypt hash for "pass"
+		if _, err := store.GetUserByEmail(mockRequest.email); err == nil {
+			continue
+		}
+
+		u := models.User{Name: "abc", Email: mockRequest.email, Password: []byte("$2a$10$4yRvhHitq43PspRFg1wDEewAcurn1tA3H/Njo067YP9yRKjVU5sae")} // $2a$10$4yRvhHitq43PspRFg1wDEewAcurn1tA3H/Njo067YP9yRKjVU5sae is bcrypt hash for "pass"
+		if err := store.CreateUser(&u); err != nil {
+			t.Fatal(err)
 		}
 	}
-	return u, nil
 }
 
 func TestSignIn(t *testing.T) {
-	oldGetUserByEmail := getUserByEmail
-	defer func() {
-		getUserByEmail = oldGetUserByEmail
-	}()
-	getUserByEmail = mockGetUserByEmail
+	c, store := newTestController()
+	seedSignInUsers(t, store)
 
 	for _, mockRequest := range mockSignInRequests {
 		reqBody := url.Values{}
@@ -145,7 +181,7 @@ func TestSignIn(t *testing.T) {
 		rr := httptest.NewRecorder()
 		var user *models.User
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			user = SignIn(w, r)
+			user = c.SignIn(w, r)
 		})
 		handler.ServeHTTP(rr, req)
 
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/mail"
+	"github.com/vabshere/vernacular-auth/models"
+	"github.com/vabshere/vernacular-auth/utils"
+)
+
+// errInvalidToken is returned by parseVerifyToken for tokens not signed by the app or expired
+var errInvalidToken = errors.New("controllers: invalid token")
+
+// VerifyEmail marks the email of the user a verification token was sent to as verified
+func (c *Controller) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	userId, email, err := parseVerifyToken(c.VerifyKey, r.FormValue("token"), time.Now())
+	if err != nil {
+		utils.Respond(1, "Invalid or expired token", http.StatusBadRequest, w, r)
+		return
+	}
+
+	user, err := c.Users.GetUserById(userId)
+	if errors.Is(err, models.ErrUserNotFound) || (err == nil && user.Email != email) {
+		// the account was deleted or its email changed since the token was sent
+		utils.Respond(1, "Invalid or expired token", http.StatusBadRequest, w, r)
+		return
+	}
+
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := c.Users.SetEmailVerified(user.Id); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	utils.Respond(0, "Success", http.StatusOK, w, r)
+	return
+}
+
+// ResendVerification emails a new verification token to the submitted email, at most once per auth.verify_resend_interval.
+// It answers the same, and as fast, whether or not the email belongs to an unverified user, so accounts cannot be discovered through it.
+func (c *Controller) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	email := template.HTMLEscapeString(r.FormValue("email"))
+	if len(email) == 0 {
+		utils.Respond(1, "Invalid submission", http.StatusBadRequest, w, r)
+		return
+	}
+
+	if !c.resends.allow(email, time.Now()) {
+		utils.Respond(1, "Too many requests", http.StatusTooManyRequests, w, r)
+		return
+	}
+
+	user, err := c.Users.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	// sent after answering, like password reset emails, so the time taken does not tell whether the email belongs to a user
+	if err == nil && !user.EmailVerified {
+		c.Go(func(ctx context.Context) { c.sendVerification(ctx, user) })
+	}
+
+	utils.Respond(0, "Success", http.StatusOK, w, r)
+	return
+}
+
+// sendVerification emails a verification token to user. Failures are logged, the account works without it and the email can be resent.
+func (c *Controller) sendVerification(ctx context.Context, user *models.User) {
+	lifetime := time.Duration(c.Config.Auth.VerifyLifetime) * time.Second
+	token := signVerifyToken(c.VerifyKey, user.Id, user.Email, time.Now().Add(lifetime))
+	body := fmt.Sprintf("Welcome! Confirm this is your email address with:\n\n%s\n\nIt expires in %s. If you did not sign up, ignore this email.\n",
+		tokenLink(c.Config.Auth.VerifyURL, token), lifetime)
+	if err := c.Mailer.Send(ctx, mail.Message{To: user.Email, Subject: "Verify your email", Body: body}); err != nil {
+		c.Logger.Printf("sending verification email: %v", err)
+	}
+}
+
+// tokenLink returns page with token in its token query parameter, or the bare token when page is empty
+func tokenLink(page, token string) string {
+	u, err := url.Parse(page)
+	if page == "" || err != nil {
+		return token
+	}
+
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// signVerifyToken returns a token proving the user with userId owned email, valid until expires: base64(id:expires:email).base64(HMAC-SHA256)
+func signVerifyToken(key []byte, userId int, email string, expires time.Time) string {
+	payload := strconv.Itoa(userId) + ":" + strconv.FormatInt(expires.Unix(), 10) + ":" + email
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(verifyMAC(key, payload))
+}
+
+// parseVerifyToken returns the user id and email of a token signed by signVerifyToken, or errInvalidToken when tampered with or expired at now
+func parseVerifyToken(key []byte, token string, now time.Time) (int, string, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, "", errInvalidToken
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, verifyMAC(key, string(payload))) {
+		return 0, "", errInvalidToken
+	}
+
+	fields := strings.SplitN(string(payload), ":", 3)
+	if len(fields) != 3 {
+		return 0, "", errInvalidToken
+	}
+
+	userId, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, "", errInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return 0, "", errInvalidToken
+	}
+
+	return userId, fields[2], nil
+}
+
+// verifyMAC returns the HMAC-SHA256 of payload, domain separated so no other token signed with key can pass for a verification token
+func verifyMAC(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte("verify-email:" + payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	key, now := []byte("0123456789abcdef0123456789abcdef"), time.Now()
+	token := signVerifyToken(key, 7, "foo:bar@bar.com", now.Add(time.Minute))
+	if id, email, err := parseVerifyToken(key, token, now); err != nil || id != 7 || email != "foo:bar@bar.com" {
+		t.Fatalf("got %d, %q, %v want 7, foo:bar@bar.com", id, email, err)
+	}
+
+	if _, _, err := parseVerifyToken(key, token, now.Add(time.Minute)); err != errInvalidToken {
+		t.Errorf("expired token was accepted")
+	}
+
+	if _, _, err := parseVerifyToken([]byte("another key of thirty two bytes!"), token, now); err != errInvalidToken {
+		t.Errorf("token signed with another key was accepted")
+	}
+
+	forged := signVerifyToken(key, 8, "foo:bar@bar.com", now.Add(time.Minute))
+	if _, _, err := parseVerifyToken(key, forged[:len(forged)/2]+token[len(token)/2:], now); err != errInvalidToken {
+		t.Errorf("spliced token was accepted")
+	}
+}
@@ -0,0 +1,115 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/config"
+)
+
+// Message is an email sent to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers the emails of the app, e.g. password reset links. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// New returns the Mailer for the configured provider. logger is used by the log provider.
+func New(c config.Mail, logger *log.Logger) (Mailer, error) {
+	switch c.Provider {
+	case "log":
+		return &LogMailer{Logger: logger}, nil
+	case "smtp":
+		return NewSMTPMailer(c.SMTP.Addr, c.SMTP.Username, c.SMTP.Password, c.From), nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	}
+
+	return nil, fmt.Errorf("mail: unknown provider %q", c.Provider)
+}
+
+// LogMailer logs the recipient and subject of emails instead of sending them, for development.
+// Bodies are left out as they carry live tokens, e.g. password reset links.
+type LogMailer struct {
+	Logger *log.Logger
+}
+
+// Send logs the recipient and subject of msg
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.Logger.Printf("mail to %s: %s", msg.To, msg.Subject)
+	return nil
+}
+
+// MemoryMailer keeps sent emails in memory, for tests
+type MemoryMailer struct {
+	lock     sync.Mutex
+	messages []Message
+}
+
+// Send records msg
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the emails sent so far, oldest first
+func (m *MemoryMailer) Messages() []Message {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// SMTPMailer sends emails through an SMTP server, authenticating with PLAIN auth when a username is set
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer returns a Mailer sending from the address from through the SMTP server at addr, a host:port pair
+func NewSMTPMailer(addr, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+// Send delivers msg. net/smtp cannot be cancelled, so ctx is only checked before connecting.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("mail: header values must not contain line breaks")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(b.String())); err != nil {
+		return fmt.Errorf("mail: sending to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
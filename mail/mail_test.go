@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/vabshere/vernacular-auth/config"
+)
+
+func TestNew(t *testing.T) {
+	for provider, want := range map[string]string{"log": "*mail.LogMailer", "smtp": "*mail.SMTPMailer", "memory": "*mail.MemoryMailer"} {
+		m, err := New(config.Mail{Provider: provider, SMTP: config.SMTP{Addr: "localhost:25"}}, log.Default())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := fmt.Sprintf("%T", m); got != want {
+			t.Errorf("provider %s: got %s want %s", provider, got, want)
+		}
+	}
+
+	if _, err := New(config.Mail{Provider: "pigeon"}, log.Default()); err == nil {
+		t.Errorf("unknown provider was accepted")
+	}
+}
+
+func TestLogMailerOmitsBody(t *testing.T) {
+	var buf bytes.Buffer
+	m := &LogMailer{Logger: log.New(&buf, "", 0)}
+	if err := m.Send(context.Background(), Message{To: "foo@bar.com", Subject: "Reset your password", Body: "secret-token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); !strings.Contains(got, "foo@bar.com") || strings.Contains(got, "secret-token") {
+		t.Errorf("got %q want the recipient logged without the body", got)
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+	msg := Message{To: "foo@bar.com", Subject: "hi", Body: "hello"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := m.Messages()
+	if len(messages) != 1 || messages[0] != msg {
+		t.Errorf("got %+v want %+v", messages, msg)
+	}
+
+	messages[0].To = "changed"
+	if m.Messages()[0].To != "foo@bar.com" {
+		t.Errorf("Messages shares memory with the mailer")
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	m := NewSMTPMailer("localhost:25", "", "", "no-reply@localhost")
+	if err := m.Send(context.Background(), Message{To: "foo@bar.com\r\nBcc: x@y.z", Subject: "hi"}); err == nil {
+		t.Errorf("recipient with a line break was accepted")
+	}
+}
@@ -1,17 +1,93 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/vabshere/vernacular-auth/app"
+	"github.com/vabshere/vernacular-auth/config"
+	"github.com/vabshere/vernacular-auth/models"
 	"github.com/vabshere/vernacular-auth/routes"
-	"github.com/vabshere/vernacular-auth/utils"
-	_ "github.com/vabshere/vernacular-auth/utils/session/providers/memory"
 )
 
 func main() {
-	utils.Run()
-	r := routes.Init()
-	println("running server")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	configPath := flag.String("config", os.Getenv(config.EnvPrefix+"CONFIG"), "path of a JSON or YAML configuration file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(cfg.Database, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the app until the server fails or SIGINT or SIGTERM is received.
+// On a signal in flight requests are given server.shutdown_timeout seconds to finish, then the session store and the database pool are closed.
+func run(cfg *config.Config) error {
+	var db *sql.DB
+	if cfg.Database.Driver != "memory" {
+		var err error
+		db, err = models.OpenDB(cfg.Database)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+		if cfg.Database.AutoMigrate {
+			if err := autoMigrate(db, cfg.Database.Driver); err != nil {
+				return err
+			}
+		}
+	}
+
+	a, err := app.New(cfg, db, log.Default())
+	if err != nil {
+		return err
+	}
+
+	routes.Init(a)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: cfg.Server.Addr, Handler: a}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	a.Logger.Println("running server")
+	select {
+	case err := <-serveErr:
+		a.Close(context.Background())
+		return err
+	case <-ctx.Done():
+	}
+
+	stop()
+	a.Logger.Println("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		a.Close(shutdownCtx)
+		return err
+	}
+
+	return a.Close(shutdownCtx)
 }
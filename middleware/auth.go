@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vabshere/vernacular-auth/utils"
+)
+
+// RequireAuth returns middleware rejecting requests without a signed in user with a 401 JSON response. It must run behind LoadSession.
+// When loginURL is not empty, browser navigations are redirected there instead, with the requested path in the next query parameter.
+func RequireAuth(loginURL string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := FromContext(r.Context()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if loginURL != "" && isBrowser(r) {
+				http.Redirect(w, r, loginLink(loginURL, r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+
+			utils.Respond(1, "Unauthorized", http.StatusUnauthorized, w, r)
+		})
+	}
+}
+
+// loginLink returns loginURL with next in its next query parameter, keeping the parameters loginURL already has
+func loginLink(loginURL, next string) string {
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		return loginURL
+	}
+
+	q := u.Query()
+	q.Set("next", next)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// isBrowser reports whether r is a page navigation, as opposed to an API call expecting JSON
+func isBrowser(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// RequireVerified rejects requests whose signed in user did not verify their email with a 403 JSON response. It must run behind RequireAuth.
+func RequireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, ok := FromContext(r.Context()); ok && u.EmailVerified {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		utils.Respond(1, "Email not verified", http.StatusForbidden, w, r)
+	})
+}
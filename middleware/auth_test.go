@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vabshere/vernacular-auth/models"
+)
+
+func TestRequireAuth(t *testing.T) {
+	handler := RequireAuth("/login")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/home", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %d %q want a 401 JSON response", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/home?tab=1", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	handler.ServeHTTP(w, r)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/login?next=%2Fhome%3Ftab%3D1" {
+		t.Errorf("got %d to %q want a redirect to the login page", w.Code, loc)
+	}
+
+	if loc := loginLink("https://example.com/login?lang=en", "/home"); loc != "https://example.com/login?lang=en&next=%2Fhome" {
+		t.Errorf("got %q want next added to the query of the login page", loc)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/home", nil)
+	handler.ServeHTTP(w, r.WithContext(NewContext(context.Background(), nil, nil, &models.User{Id: 1})))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("got %d want the request of a signed in user to go through", w.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/vabshere/vernacular-auth/models"
+	"github.com/vabshere/vernacular-auth/utils"
+	"github.com/vabshere/vernacular-auth/utils/session"
+)
+
+// contextKey is the type of the request context key, unexported so no other package can collide with it
+type contextKey struct{}
+
+// requestSession is what LoadSession stores in the request context
+type requestSession struct {
+	manager *session.Manager
+	index   models.SessionIndex
+	session session.SessionV2
+	user    *models.User
+	handle  string
+}
+
+// LoadSession returns middleware loading the session of every request from manager once, along with its signed in user, into the request context.
+// Requests without a session, or whose session expired, go on with neither. Read them back with FromContext, SessionFromContext and ManagerFromContext.
+// When index is not nil, signed in sessions missing from it were revoked and are destroyed.
+// When users is not nil, the user is read back from it, and sessions of deleted users or of an older session generation are destroyed.
+func LoadSession(manager *session.Manager, index models.SessionIndex, users models.UserStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rs := requestSession{manager: manager, index: index}
+			s, err := manager.SessionResume(w, r)
+			if err != nil && !errors.Is(err, session.ErrNoSession) {
+				utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+				return
+			}
+
+			if err == nil {
+				rs.session = s
+				rs.user, err = utils.SessionGetUser(s, r)
+				if err != nil && !errors.Is(err, session.ErrNoValue) {
+					utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+					return
+				}
+			}
+
+			if rs.user != nil && users != nil {
+				rs.user, err = checkGeneration(users, rs.user)
+				if errors.Is(err, errStaleSession) {
+					err = manager.SessionDestroy(w, r)
+					rs.session = nil
+				}
+
+				if err != nil {
+					utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+					return
+				}
+			}
+
+			if rs.user != nil && index != nil {
+				rs.handle, err = checkHandle(index, manager.Policy(), rs.user, s, r)
+				if errors.Is(err, models.ErrSessionNotFound) {
+					err = manager.SessionDestroy(w, r)
+					rs.session, rs.user = nil, nil
+				}
+
+				if err != nil {
+					utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, rs)))
+		})
+	}
+}
+
+// errStaleSession is returned by checkGeneration for the session of a deleted user or of an older session generation
+var errStaleSession = errors.New("middleware: stale session")
+
+// checkGeneration returns the stored user signed in the session as user, or errStaleSession when the session no longer is valid for them
+func checkGeneration(users models.UserStore, user *models.User) (*models.User, error) {
+	stored, err := users.GetUserById(user.Id)
+	if errors.Is(err, models.ErrUserNotFound) {
+		return nil, errStaleSession
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.SessionGeneration != user.SessionGeneration {
+		return nil, errStaleSession
+	}
+
+	return stored, nil
+}
+
+// NewContext returns a copy of ctx carrying manager, the session s and its signed in user, either of which may be nil
+func NewContext(ctx context.Context, manager *session.Manager, s session.SessionV2, user *models.User) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestSession{manager: manager, session: s, user: user})
+}
+
+// FromContext returns the signed in user stored by LoadSession
+func FromContext(ctx context.Context) (*models.User, bool) {
+	rs, _ := ctx.Value(contextKey{}).(requestSession)
+	return rs.user, rs.user != nil
+}
+
+// SessionFromContext returns the session stored by LoadSession
+func SessionFromContext(ctx context.Context) (session.SessionV2, bool) {
+	rs, _ := ctx.Value(contextKey{}).(requestSession)
+	return rs.session, rs.session != nil
+}
+
+// HandleFromContext returns the handle of the signed in session in the SessionIndex, empty without one
+func HandleFromContext(ctx context.Context) string {
+	rs, _ := ctx.Value(contextKey{}).(requestSession)
+	return rs.handle
+}
+
+// ManagerFromContext returns the session manager stored by LoadSession, nil outside of it
+func ManagerFromContext(ctx context.Context) *session.Manager {
+	rs, _ := ctx.Value(contextKey{}).(requestSession)
+	return rs.manager
+}
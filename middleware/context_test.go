@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vabshere/vernacular-auth/models"
+	"github.com/vabshere/vernacular-auth/utils"
+	"github.com/vabshere/vernacular-auth/utils/session"
+	_ "github.com/vabshere/vernacular-auth/utils/session/providers/memory"
+)
+
+func TestLoadSession(t *testing.T) {
+	manager, err := session.NewManager("memory", "sid", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user *models.User
+	var found bool
+	handler := LoadSession(manager, nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, found = FromContext(r.Context())
+		if ManagerFromContext(r.Context()) != manager {
+			t.Errorf("manager missing from the context")
+		}
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if found {
+		t.Errorf("got user %+v from a request without session", user)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	s, err := manager.SessionStart(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := utils.SessionSetUser(&models.User{Id: 7, Name: "foo", Email: "foo@bar.com"}, s, r); err != nil {
+		t.Fatal(err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !found || user.Id != 7 || user.Email != "foo@bar.com" {
+		t.Errorf("got user %+v, %v want the signed in user", user, found)
+	}
+}
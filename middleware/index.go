@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/models"
+	"github.com/vabshere/vernacular-auth/utils/session"
+)
+
+// handleKey is the session key holding the handle of a signed in session in the SessionIndex
+const handleKey = "session.handle"
+
+// maxTouchInterval is the longest time, in seconds, between two writes of the last use of a session to the SessionIndex
+const maxTouchInterval = 60
+
+// maxUserAgent is the longest user agent recorded, the size of the user_agent column
+const maxUserAgent = 255
+
+// trackSession records the session user just signed in with in the index stored by LoadSession, replacing the entry of a previous sign in.
+// Entries of the user idle for longer than the expiry policy are pruned on the way.
+func trackSession(r *http.Request, manager *session.Manager, user *models.User, s session.SessionV2) error {
+	rs, _ := r.Context().Value(contextKey{}).(requestSession)
+	if rs.index == nil {
+		return nil
+	}
+
+	ctx := r.Context()
+	old, err := session.GetTyped[string](ctx, s, handleKey)
+	if err == nil {
+		if err := rs.index.DeleteSession(old); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, session.ErrNoValue) {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if err := PruneIdle(rs.index, manager.Policy(), user.Id, now); err != nil {
+		return err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	us := models.UserSession{Handle: hex.EncodeToString(b), UserId: user.Id, Created: now, LastSeen: now, IP: clientIP(r), UserAgent: r.UserAgent()}
+	if len(us.UserAgent) > maxUserAgent {
+		us.UserAgent = us.UserAgent[:maxUserAgent]
+	}
+
+	if err := rs.index.AddSession(&us); err != nil {
+		return err
+	}
+
+	return s.Set(ctx, handleKey, us.Handle)
+}
+
+// checkHandle returns the handle of the session s of user, or models.ErrSessionNotFound when it is missing from index because it was revoked.
+// Sessions signed in before the index existed carry no handle and count as revoked.
+func checkHandle(index models.SessionIndex, policy session.ExpiryPolicy, user *models.User, s session.SessionV2, r *http.Request) (string, error) {
+	handle, err := session.GetTyped[string](r.Context(), s, handleKey)
+	if errors.Is(err, session.ErrNoValue) {
+		return "", models.ErrSessionNotFound
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	us, err := index.GetSession(handle)
+	if err != nil {
+		return "", err
+	}
+
+	if us.UserId != user.Id {
+		return "", models.ErrSessionNotFound
+	}
+
+	if now := time.Now().Unix(); now-us.LastSeen >= touchInterval(policy) {
+		if err := index.TouchSession(handle, now); err != nil {
+			return "", err
+		}
+	}
+
+	return handle, nil
+}
+
+// touchInterval is how often, in seconds, the last use of a session is written to the SessionIndex.
+// Like the Manager it writes every tenth of the idle timeout, but at least every maxTouchInterval.
+func touchInterval(policy session.ExpiryPolicy) int64 {
+	interval := int64(policy.IdleTimeout / 10)
+	if policy.IdleTimeout == 0 || interval > maxTouchInterval {
+		return maxTouchInterval
+	}
+
+	if interval < 1 {
+		return 1
+	}
+
+	return interval
+}
+
+// PruneIdle removes the entries of user idle for longer than the idle timeout of policy.
+// LastSeen lags behind by up to a touchInterval, which is allowed for so active sessions are never pruned.
+func PruneIdle(index models.SessionIndex, policy session.ExpiryPolicy, userId int, now int64) error {
+	if policy.IdleTimeout == 0 {
+		return nil
+	}
+
+	return index.PruneSessions(userId, now-int64(policy.IdleTimeout)-touchInterval(policy))
+}
+
+// clientIP returns the address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/vabshere/vernacular-auth/utils/session"
+)
+
+func TestTouchInterval(t *testing.T) {
+	for idle, want := range map[int]int64{0: 60, 5: 1, 30: 3, 600: 60, 3600: 60} {
+		if got := touchInterval(session.ExpiryPolicy{IdleTimeout: idle}); got != want {
+			t.Errorf("got %d for an idle timeout of %d want %d", got, idle, want)
+		}
+	}
+}
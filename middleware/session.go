@@ -1,19 +1,154 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/vabshere/vernacular-auth/models"
 	"github.com/vabshere/vernacular-auth/utils"
+	"github.com/vabshere/vernacular-auth/utils/session"
 )
 
+// pendingKey and pendingAtKey hold the user, and the unix time, of a sign in waiting for its second factor
+const (
+	pendingKey   = "mfa.pending"
+	pendingAtKey = "mfa.pending_at"
+)
+
+// PendingLifetime is the number of seconds a sign in waits for its second factor
+const PendingLifetime = 300
+
+// SessionReset wraps handlers authenticating a user. When the handler returns a user, the session id is rotated to prevent fixation and the user is stored in the session.
+// An existing session of the same user keeps its values, one of another user is replaced by a new session.
+// A user with TOTP enabled who is not already signed in is only marked pending in the session, and answered mfa_required, until SecondFactor completes the sign in.
+// It must run behind LoadSession.
 type SessionReset func(http.ResponseWriter, *http.Request) *models.User
 
 func (handler SessionReset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	utils.GlobalSessions.SessionDestroy(w, r)
-	if user := handler(w, r); user != nil {
-		session := utils.GlobalSessions.SessionStart(w, r)
-		utils.SessionSetUser(user, &session, r)
-		utils.RespondJson(0, user, http.StatusOK, w, r)
+	user := handler(w, r)
+	if user == nil {
+		return
+	}
+
+	if current, ok := FromContext(r.Context()); user.TOTPEnabled && (!ok || current.Id != user.Id) {
+		startPending(user, w, r)
+		return
+	}
+
+	signIn(user, w, r)
+}
+
+// SecondFactor wraps handlers checking the second factor of the user PendingUser returns. When the handler returns the user, the sign in completes as with SessionReset.
+// It must run behind LoadSession.
+type SecondFactor func(http.ResponseWriter, *http.Request) *models.User
+
+func (handler SecondFactor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := handler(w, r)
+	if user == nil {
+		return
+	}
+
+	signIn(user, w, r)
+}
+
+// PendingUser returns the id of the user whose sign in waits for its second factor in the session of r, if not older than PendingLifetime
+func PendingUser(r *http.Request) (int, bool) {
+	ctx := r.Context()
+	s, ok := SessionFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+
+	id, err := session.GetTyped[int](ctx, s, pendingKey)
+	if err != nil {
+		return 0, false
+	}
+
+	at, err := session.GetTyped[int64](ctx, s, pendingAtKey)
+	if err != nil || time.Now().Unix()-at > PendingLifetime {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// startPending rotates the session of r and records the sign in of user as waiting for its second factor
+func startPending(user *models.User, w http.ResponseWriter, r *http.Request) {
+	manager := ManagerFromContext(r.Context())
+	s, err := resetSession(manager, user, w, r)
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
 	}
+
+	if err := s.Set(r.Context(), pendingKey, user.Id); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := s.Set(r.Context(), pendingAtKey, time.Now().Unix()); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	manager.SessionSave(w, s)
+	utils.RespondJson(0, map[string]bool{"mfa_required": true}, http.StatusOK, w, r)
+}
+
+// signIn rotates the session of r, stores user in it and answers with user
+func signIn(user *models.User, w http.ResponseWriter, r *http.Request) {
+	manager := ManagerFromContext(r.Context())
+	s, err := resetSession(manager, user, w, r)
+	if err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	for _, key := range []string{pendingKey, pendingAtKey} {
+		if err := s.Delete(r.Context(), key); err != nil {
+			utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+			return
+		}
+	}
+
+	if err := utils.SessionSetUser(user, s, r); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	if err := trackSession(r, manager, user, s); err != nil {
+		utils.Respond(1, "Error", http.StatusInternalServerError, w, r)
+		return
+	}
+
+	manager.SessionSave(w, s)
+	utils.RespondJson(0, user, http.StatusOK, w, r)
+}
+
+// resetSession returns the session of the request under a new id when it belongs to user or to nobody, otherwise a new session
+func resetSession(manager *session.Manager, user *models.User, w http.ResponseWriter, r *http.Request) (session.SessionV2, error) {
+	if current, ok := SessionFromContext(r.Context()); ok {
+		id, err := session.GetTyped[int](r.Context(), current, "id")
+		if err != nil && !errors.Is(err, session.ErrNoValue) {
+			return nil, err
+		}
+
+		if err != nil || id == user.Id {
+			s, err := manager.Regenerate(w, r)
+			if err == nil {
+				return s, nil
+			}
+
+			if !errors.Is(err, session.ErrRegenerateUnsupported) && !errors.Is(err, session.ErrNoSession) {
+				return nil, err
+			}
+		}
+
+		if err := manager.SessionDestroy(w, r); err != nil {
+			return nil, err
+		}
+	}
+
+	return manager.SessionNew(w, r)
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/vabshere/vernacular-auth/config"
+	"github.com/vabshere/vernacular-auth/migrations"
+	"github.com/vabshere/vernacular-auth/models"
+)
+
+// migrate runs the "migrate up|down|status" subcommand against the configured database
+func migrate(c config.Database, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status")
+	}
+
+	db, err := models.OpenDB(c)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+	m, err := migrations.New(db, c.Driver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		for _, mig := range applied {
+			fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+		}
+
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+
+		return err
+	case "down":
+		mig, err := m.Down()
+		if err != nil {
+			return err
+		}
+
+		if mig == nil {
+			fmt.Println("no applied migrations")
+			return nil
+		}
+
+		fmt.Printf("rolled back %04d_%s\n", mig.Version, mig.Name)
+		return nil
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command %q, want up, down or status", args[0])
+}
+
+// autoMigrate applies pending migrations at startup on the shared pool
+func autoMigrate(db *sql.DB, driver string) error {
+	m, err := migrations.New(db, driver)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.Up()
+	return err
+}
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestUpDownStatus(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+	m, err := New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != len(m.migrations) {
+		t.Fatalf("applied %d migrations want %d", len(applied), len(m.migrations))
+	}
+
+	if applied, err := m.Up(); err != nil || len(applied) != 0 {
+		t.Fatalf("second Up applied %d migrations with error %v", len(applied), err)
+	}
+
+	if _, err := db.Exec("INSERT INTO user (name, email, password) VALUES ('foo', 'foo@bar.com', 'hash')"); err != nil {
+		t.Fatal(err)
+	}
+
+	last := m.migrations[len(m.migrations)-1]
+	rolledBack, err := m.Down()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rolledBack == nil || rolledBack.Version != last.Version {
+		t.Fatalf("Down rolled back %v want version %d", rolledBack, last.Version)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range statuses {
+		if want := s.Version != last.Version; s.Applied != want {
+			t.Errorf("version %d applied = %v want %v", s.Version, s.Applied, want)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user (
+  id int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  email varchar(50) DEFAULT NULL UNIQUE,
+  name varchar(30) DEFAULT NULL,
+  password binary(60) DEFAULT NULL
+);
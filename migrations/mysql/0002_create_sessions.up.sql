@@ -0,0 +1,6 @@
+CREATE TABLE sessions (
+  sid varchar(64) NOT NULL PRIMARY KEY,
+  data blob NOT NULL,
+  time_accessed bigint NOT NULL,
+  INDEX sessions_time_accessed (time_accessed)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE user_sessions (
+  handle varchar(64) NOT NULL PRIMARY KEY,
+  user_id int NOT NULL,
+  created bigint NOT NULL,
+  last_seen bigint NOT NULL,
+  ip varchar(64) NOT NULL,
+  user_agent varchar(255) NOT NULL,
+  INDEX user_sessions_user_id (user_id),
+  FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+);
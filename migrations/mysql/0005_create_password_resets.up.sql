@@ -0,0 +1,7 @@
+CREATE TABLE password_resets (
+  token_hash varchar(64) NOT NULL PRIMARY KEY,
+  user_id int NOT NULL,
+  expires bigint NOT NULL,
+  INDEX password_resets_user_id (user_id),
+  FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+);
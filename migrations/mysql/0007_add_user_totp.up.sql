@@ -0,0 +1,9 @@
+ALTER TABLE user ADD COLUMN totp_secret varbinary(64) DEFAULT NULL;
+ALTER TABLE user ADD COLUMN totp_enabled tinyint(1) NOT NULL DEFAULT 0;
+ALTER TABLE user ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+CREATE TABLE user_recovery_codes (
+  user_id int NOT NULL,
+  code_hash varchar(64) NOT NULL,
+  PRIMARY KEY (user_id, code_hash),
+  FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+CREATE TABLE sessions (
+  sid VARCHAR(64) PRIMARY KEY,
+  data BYTEA NOT NULL,
+  time_accessed BIGINT NOT NULL
+);
+CREATE INDEX sessions_time_accessed ON sessions (time_accessed);
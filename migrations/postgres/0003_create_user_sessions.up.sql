@@ -0,0 +1,9 @@
+CREATE TABLE user_sessions (
+  handle VARCHAR(64) PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES "user" (id) ON DELETE CASCADE,
+  created BIGINT NOT NULL,
+  last_seen BIGINT NOT NULL,
+  ip VARCHAR(64) NOT NULL,
+  user_agent VARCHAR(255) NOT NULL
+);
+CREATE INDEX user_sessions_user_id ON user_sessions (user_id);
@@ -0,0 +1,6 @@
+CREATE TABLE password_resets (
+  token_hash VARCHAR(64) PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES "user" (id) ON DELETE CASCADE,
+  expires BIGINT NOT NULL
+);
+CREATE INDEX password_resets_user_id ON password_resets (user_id);
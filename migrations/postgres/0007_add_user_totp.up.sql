@@ -0,0 +1,8 @@
+ALTER TABLE "user" ADD COLUMN totp_secret BYTEA;
+ALTER TABLE "user" ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE "user" ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+CREATE TABLE user_recovery_codes (
+  user_id INTEGER NOT NULL REFERENCES "user" (id) ON DELETE CASCADE,
+  code_hash VARCHAR(64) NOT NULL,
+  PRIMARY KEY (user_id, code_hash)
+);
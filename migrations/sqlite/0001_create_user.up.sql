@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  email VARCHAR(50) UNIQUE,
+  name VARCHAR(30),
+  password BLOB
+);
@@ -0,0 +1,6 @@
+CREATE TABLE sessions (
+  sid VARCHAR(64) PRIMARY KEY,
+  data BLOB NOT NULL,
+  time_accessed INTEGER NOT NULL
+);
+CREATE INDEX sessions_time_accessed ON sessions (time_accessed);
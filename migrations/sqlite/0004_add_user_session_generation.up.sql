@@ -0,0 +1 @@
+ALTER TABLE user ADD COLUMN session_generation INTEGER NOT NULL DEFAULT 0;
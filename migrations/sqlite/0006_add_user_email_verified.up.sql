@@ -0,0 +1,3 @@
+ALTER TABLE user ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT 0;
+-- accounts created before verification existed are trusted
+UPDATE user SET email_verified=1;
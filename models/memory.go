@@ -0,0 +1,353 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is the UserStore keeping users in process memory. Useful for tests and running without a database.
+type MemoryStore struct {
+	lock   sync.RWMutex
+	nextId int
+	users  map[int]User
+	resets map[string]resetToken
+	// recoveryCodes holds the recovery code hashes of each user
+	recoveryCodes map[int]map[string]bool
+}
+
+// resetToken is a password reset token kept by MemoryStore under its hash
+type resetToken struct {
+	userId  int
+	expires int64
+}
+
+// NewMemoryStore returns an empty in-memory UserStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nextId: 1, users: make(map[int]User), resets: make(map[string]resetToken), recoveryCodes: make(map[int]map[string]bool)}
+}
+
+// CreateUser saves a new user and sets its Id
+func (s *MemoryStore) CreateUser(u *User) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.emailTaken(u.Email, 0) {
+		return ErrEmailTaken
+	}
+
+	u.Id = s.nextId
+	s.nextId++
+	s.users[u.Id] = copyUser(u)
+	return nil
+}
+
+// GetUserById returns the user with the given id
+func (s *MemoryStore) GetUserById(id int) (*User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if u, ok := s.users[id]; ok {
+		c := copyUser(&u)
+		return &c, nil
+	}
+
+	return nil, ErrUserNotFound
+}
+
+// GetUserByEmail returns the user associated with given email
+func (s *MemoryStore) GetUserByEmail(email string) (*User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, u := range s.users {
+		if u.Email == email {
+			c := copyUser(&u)
+			return &c, nil
+		}
+	}
+
+	return nil, ErrUserNotFound
+}
+
+// UpdateUser overwrites the name, email and password of the stored user having u.Id
+func (s *MemoryStore) UpdateUser(u *User) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old, ok := s.users[u.Id]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	if s.emailTaken(u.Email, u.Id) {
+		return ErrEmailTaken
+	}
+
+	c := copyUser(u)
+	c.SessionGeneration = old.SessionGeneration
+	c.EmailVerified = old.EmailVerified
+	c.TOTPSecret, c.TOTPEnabled, c.TOTPLastStep = old.TOTPSecret, old.TOTPEnabled, old.TOTPLastStep
+	s.users[u.Id] = c
+	return nil
+}
+
+// IncrementSessionGeneration invalidates every session of the user with the given id
+func (s *MemoryStore) IncrementSessionGeneration(id int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	u.SessionGeneration++
+	s.users[id] = u
+	return nil
+}
+
+// DeleteUser removes the user with the given id
+func (s *MemoryStore) DeleteUser(id int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return ErrUserNotFound
+	}
+
+	delete(s.users, id)
+	delete(s.recoveryCodes, id)
+	s.deleteResetTokens(id)
+	return nil
+}
+
+// SetEmailVerified records that the user with the given id proved to own their email
+func (s *MemoryStore) SetEmailVerified(id int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	u.EmailVerified = true
+	s.users[id] = u
+	return nil
+}
+
+// SetTOTP stores the TOTP secret of the user, enabled once confirmed, and forgets the last used code. A nil secret disables TOTP.
+func (s *MemoryStore) SetTOTP(id int, secret []byte, enabled bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	u.TOTPSecret = nil
+	if secret != nil {
+		u.TOTPSecret = append([]byte(nil), secret...)
+	}
+
+	u.TOTPEnabled, u.TOTPLastStep = enabled, 0
+	s.users[id] = u
+	return nil
+}
+
+// UseTOTPStep records that the TOTP code of the given time step was used, or returns ErrCodeUsed when a code of that step or a later one already was
+func (s *MemoryStore) UseTOTPStep(id int, step int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	u, ok := s.users[id]
+	if !ok || u.TOTPLastStep >= step {
+		return ErrCodeUsed
+	}
+
+	u.TOTPLastStep = step
+	s.users[id] = u
+	return nil
+}
+
+// SetRecoveryCodes replaces the recovery codes of the user with the given hashes
+func (s *MemoryStore) SetRecoveryCodes(userId int, hashes []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	codes := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		codes[hash] = true
+	}
+
+	s.recoveryCodes[userId] = codes
+	return nil
+}
+
+// UseRecoveryCode deletes the recovery code of the user with the given hash, or returns ErrCodeUsed when there is none
+func (s *MemoryStore) UseRecoveryCode(userId int, hash string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.recoveryCodes[userId][hash] {
+		return ErrCodeUsed
+	}
+
+	delete(s.recoveryCodes[userId], hash)
+	return nil
+}
+
+// CreateResetToken saves the hash of a password reset token of the user, valid until expires, and drops the expired ones
+func (s *MemoryStore) CreateResetToken(userId int, tokenHash string, expires int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now().Unix()
+	for hash, t := range s.resets {
+		if t.expires <= now {
+			delete(s.resets, hash)
+		}
+	}
+
+	s.resets[tokenHash] = resetToken{userId: userId, expires: expires}
+	return nil
+}
+
+// ConsumeResetToken returns the user of the unexpired token with the given hash and deletes every reset token of that user
+func (s *MemoryStore) ConsumeResetToken(tokenHash string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, ok := s.resets[tokenHash]
+	if !ok || t.expires <= time.Now().Unix() {
+		return 0, ErrTokenNotFound
+	}
+
+	s.deleteResetTokens(t.userId)
+	return t.userId, nil
+}
+
+// deleteResetTokens removes every reset token of the user. The caller holds s.lock.
+func (s *MemoryStore) deleteResetTokens(userId int) {
+	for hash, t := range s.resets {
+		if t.userId == userId {
+			delete(s.resets, hash)
+		}
+	}
+}
+
+// ListUsers returns all the users ordered by id
+func (s *MemoryStore) ListUsers() ([]*User, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	users := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		c := copyUser(&u)
+		users = append(users, &c)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	return users, nil
+}
+
+// emailTaken reports whether a user other than exceptId already uses email
+func (s *MemoryStore) emailTaken(email string, exceptId int) bool {
+	for id, u := range s.users {
+		if id != exceptId && u.Email == email {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyUser returns a copy of u that shares no memory with it
+func copyUser(u *User) User {
+	c := *u
+	c.Password = append(password(nil), u.Password...)
+	if u.TOTPSecret != nil {
+		c.TOTPSecret = append([]byte(nil), u.TOTPSecret...)
+	}
+	return c
+}
+
+// MemorySessionIndex is the SessionIndex keeping sessions in process memory
+type MemorySessionIndex struct {
+	lock     sync.RWMutex
+	sessions map[string]UserSession
+}
+
+// NewMemorySessionIndex returns an empty in-memory SessionIndex
+func NewMemorySessionIndex() *MemorySessionIndex {
+	return &MemorySessionIndex{sessions: make(map[string]UserSession)}
+}
+
+// AddSession records a new signed in session
+func (s *MemorySessionIndex) AddSession(us *UserSession) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sessions[us.Handle] = *us
+	return nil
+}
+
+// GetSession returns the session with the given handle
+func (s *MemorySessionIndex) GetSession(handle string) (*UserSession, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if us, ok := s.sessions[handle]; ok {
+		return &us, nil
+	}
+
+	return nil, ErrSessionNotFound
+}
+
+// TouchSession records the last use of the session with the given handle
+func (s *MemorySessionIndex) TouchSession(handle string, lastSeen int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if us, ok := s.sessions[handle]; ok {
+		us.LastSeen = lastSeen
+		s.sessions[handle] = us
+	}
+
+	return nil
+}
+
+// ListSessions returns the sessions of the user, most recently seen first
+func (s *MemorySessionIndex) ListSessions(userId int) ([]*UserSession, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	sessions := make([]*UserSession, 0)
+	for _, us := range s.sessions {
+		if us.UserId == userId {
+			us := us
+			sessions = append(sessions, &us)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeen > sessions[j].LastSeen })
+	return sessions, nil
+}
+
+// DeleteSession removes the session with the given handle
+func (s *MemorySessionIndex) DeleteSession(handle string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, handle)
+	return nil
+}
+
+// DeleteUserSessions removes every session of the user except the one with handle except
+func (s *MemorySessionIndex) DeleteUserSessions(userId int, except string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for handle, us := range s.sessions {
+		if us.UserId == userId && handle != except {
+			delete(s.sessions, handle)
+		}
+	}
+
+	return nil
+}
+
+// PruneSessions removes the sessions of the user last seen before lastSeen
+func (s *MemorySessionIndex) PruneSessions(userId int, lastSeen int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for handle, us := range s.sessions {
+		if us.UserId == userId && us.LastSeen < lastSeen {
+			delete(s.sessions, handle)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrCodeUsed is returned by a UserStore when a one-time code was already used, or a recovery code is unknown
+var ErrCodeUsed = errors.New("models: code already used")
+
+// setRecoveryCodes replaces the recovery codes of the user in one transaction, deleting them with remove and saving each hash with insert
+func setRecoveryCodes(db *sql.DB, remove, insert string, userId int, hashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(remove, userId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, hash := range hashes {
+		if _, err := tx.Exec(insert, userId, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// checkUsed returns ErrCodeUsed when the UPDATE or DELETE consuming a code matched no rows
+func checkUsed(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrCodeUsed
+	}
+
+	return nil
+}
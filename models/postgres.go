@@ -0,0 +1,169 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// pqUniqueViolation is the SQLSTATE PostgreSQL reports for a unique constraint violation
+const pqUniqueViolation = "23505"
+
+// PostgresStore is the UserStore backed by a PostgreSQL server. "user" is a reserved word in PostgreSQL, hence the quoting in queries.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore returns a UserStore backed by the PostgreSQL database opened by OpenDB
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// CreateUser saves a user into the database
+func (s *PostgresStore) CreateUser(u *User) error {
+	err := s.db.QueryRow(`INSERT INTO "user" (name, email, password) VALUES ($1, $2, $3) RETURNING id`, u.Name, u.Email, []byte(u.Password)).Scan(&u.Id)
+	return postgresError(err)
+}
+
+// GetUserById returns the user with the given id
+func (s *PostgresStore) GetUserById(id int) (*User, error) {
+	return s.getUser(`SELECT id, email, name, password, session_generation, email_verified, totp_secret, totp_enabled, totp_last_step FROM "user" WHERE id=$1`, id)
+}
+
+// GetUserByEmail returns the user associated with given email
+func (s *PostgresStore) GetUserByEmail(email string) (*User, error) {
+	return s.getUser(`SELECT id, email, name, password, session_generation, email_verified, totp_secret, totp_enabled, totp_last_step FROM "user" WHERE email=$1`, email)
+}
+
+func (s *PostgresStore) getUser(query string, arg interface{}) (*User, error) {
+	var user User
+	err := s.db.QueryRow(query, arg).Scan(&user.Id, &user.Email, &user.Name, &user.Password, &user.SessionGeneration, &user.EmailVerified, &user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// UpdateUser overwrites the name, email and password of the user having u.Id
+func (s *PostgresStore) UpdateUser(u *User) error {
+	res, err := s.db.Exec(`UPDATE "user" SET name=$1, email=$2, password=$3 WHERE id=$4`, u.Name, u.Email, []byte(u.Password), u.Id)
+	if err != nil {
+		return postgresError(err)
+	}
+
+	return checkAffected(res)
+}
+
+// IncrementSessionGeneration invalidates every session of the user with the given id
+func (s *PostgresStore) IncrementSessionGeneration(id int) error {
+	res, err := s.db.Exec(`UPDATE "user" SET session_generation=session_generation+1 WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// SetEmailVerified records that the user with the given id proved to own their email
+func (s *PostgresStore) SetEmailVerified(id int) error {
+	res, err := s.db.Exec(`UPDATE "user" SET email_verified=TRUE WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// SetTOTP stores the TOTP secret of the user with the given id, enabled once confirmed, and forgets the last used code. A nil secret disables TOTP.
+func (s *PostgresStore) SetTOTP(id int, secret []byte, enabled bool) error {
+	res, err := s.db.Exec(`UPDATE "user" SET totp_secret=$1, totp_enabled=$2, totp_last_step=0 WHERE id=$3`, secret, enabled, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// UseTOTPStep records that the TOTP code of the given time step was used, or returns ErrCodeUsed when a code of that step or a later one already was
+func (s *PostgresStore) UseTOTPStep(id int, step int64) error {
+	res, err := s.db.Exec(`UPDATE "user" SET totp_last_step=$1 WHERE id=$2 AND totp_last_step<$3`, step, id, step)
+	if err != nil {
+		return err
+	}
+
+	return checkUsed(res)
+}
+
+// SetRecoveryCodes replaces the recovery codes of the user with the given hashes
+func (s *PostgresStore) SetRecoveryCodes(userId int, hashes []string) error {
+	return setRecoveryCodes(s.db, "DELETE FROM user_recovery_codes WHERE user_id=$1", "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userId, hashes)
+}
+
+// UseRecoveryCode deletes the recovery code of the user with the given hash, or returns ErrCodeUsed when there is none
+func (s *PostgresStore) UseRecoveryCode(userId int, hash string) error {
+	res, err := s.db.Exec("DELETE FROM user_recovery_codes WHERE user_id=$1 AND code_hash=$2", userId, hash)
+	if err != nil {
+		return err
+	}
+
+	return checkUsed(res)
+}
+
+// CreateResetToken saves the hash of a password reset token of the user, valid until expires, and drops the expired ones
+func (s *PostgresStore) CreateResetToken(userId int, tokenHash string, expires int64) error {
+	return createResetToken(s.db, "DELETE FROM password_resets WHERE expires<=$1", "INSERT INTO password_resets (token_hash, user_id, expires) VALUES ($1, $2, $3)",
+		userId, tokenHash, expires, time.Now().Unix())
+}
+
+// ConsumeResetToken returns the user of the unexpired token with the given hash and deletes every reset token of that user
+func (s *PostgresStore) ConsumeResetToken(tokenHash string) (int, error) {
+	return consumeResetToken(s.db, "SELECT user_id FROM password_resets WHERE token_hash=$1 AND expires>$2", "DELETE FROM password_resets WHERE token_hash=$1 AND expires>$2", "DELETE FROM password_resets WHERE user_id=$1", tokenHash, time.Now().Unix())
+}
+
+// DeleteUser removes the user with the given id
+func (s *PostgresStore) DeleteUser(id int) error {
+	res, err := s.db.Exec(`DELETE FROM "user" WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// ListUsers returns all the users ordered by id
+func (s *PostgresStore) ListUsers() ([]*User, error) {
+	rows, err := s.db.Query(`SELECT id, email, name, password, session_generation, email_verified, totp_secret, totp_enabled, totp_last_step FROM "user" ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	users := make([]*User, 0)
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Id, &user.Email, &user.Name, &user.Password, &user.SessionGeneration, &user.EmailVerified, &user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep); err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// postgresError maps a unique constraint violation to ErrEmailTaken
+func postgresError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+		return ErrEmailTaken
+	}
+
+	return err
+}
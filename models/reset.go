@@ -0,0 +1,53 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrTokenNotFound is returned by a UserStore when a password reset token is unknown, expired or already used
+var ErrTokenNotFound = errors.New("models: token not found")
+
+// createResetToken deletes the expired reset tokens with purge, then saves a token with insert
+func createResetToken(db *sql.DB, purge, insert string, userId int, tokenHash string, expires, now int64) error {
+	if _, err := db.Exec(purge, now); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(insert, tokenHash, userId, expires)
+	return err
+}
+
+// consumeResetToken looks the user of an unexpired token up with lookup, deletes that token with take and then every other token of the user with purge.
+// Of concurrent calls for the same token only the one deleting it succeeds, so a token is used at most once.
+func consumeResetToken(db *sql.DB, lookup, take, purge string, tokenHash string, now int64) (int, error) {
+	var userId int
+	err := db.QueryRow(lookup, tokenHash, now).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, ErrTokenNotFound
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.Exec(take, tokenHash, now)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if n == 0 {
+		return 0, ErrTokenNotFound
+	}
+
+	if _, err := db.Exec(purge, userId); err != nil {
+		return 0, err
+	}
+
+	return userId, nil
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/vabshere/vernacular-auth/utils/sqlbind"
+)
+
+// ErrSessionNotFound is returned when no signed in session has the given handle, e.g. because it was revoked
+var ErrSessionNotFound = errors.New("models: session not found")
+
+// UserSession is a signed in session of a user, as listed to them. Times are unix seconds.
+type UserSession struct {
+	// Handle identifies the session in the index. Unlike the session id it is safe to show, it cannot be used to sign in.
+	Handle    string `json:"id"`
+	UserId    int    `json:"-"`
+	Created   int64  `json:"created"`
+	LastSeen  int64  `json:"last_seen"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	// Current is set on the session of the request listing them
+	Current bool `json:"current"`
+}
+
+// SessionIndex records the signed in sessions of every user, whatever the session provider, so they can be listed and revoked
+type SessionIndex interface {
+	AddSession(s *UserSession) error
+	GetSession(handle string) (*UserSession, error)
+	TouchSession(handle string, lastSeen int64) error
+	ListSessions(userId int) ([]*UserSession, error)
+	DeleteSession(handle string) error
+	// DeleteUserSessions removes every session of the user except the one with handle except
+	DeleteUserSessions(userId int, except string) error
+	// PruneSessions removes the sessions of the user last seen before lastSeen
+	PruneSessions(userId int, lastSeen int64) error
+}
+
+// NewSessionIndex returns the SessionIndex for the configured database driver. db is ignored by the memory driver.
+func NewSessionIndex(driver string, db *sql.DB) (SessionIndex, error) {
+	switch driver {
+	case "mysql", "sqlite":
+		return &SQLSessionIndex{db: db}, nil
+	case "postgres":
+		return &SQLSessionIndex{db: db, postgres: true}, nil
+	case "memory":
+		return NewMemorySessionIndex(), nil
+	}
+
+	return nil, fmt.Errorf("models: unknown database driver %q", driver)
+}
+
+// SQLSessionIndex is the SessionIndex kept in the user_sessions table of an SQL database
+type SQLSessionIndex struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// bind rewrites ? placeholders to $n for postgres
+func (s *SQLSessionIndex) bind(query string) string {
+	return sqlbind.Rebind(s.postgres, query)
+}
+
+// AddSession records a new signed in session
+func (s *SQLSessionIndex) AddSession(us *UserSession) error {
+	_, err := s.db.Exec(s.bind("INSERT INTO user_sessions (handle, user_id, created, last_seen, ip, user_agent) VALUES (?, ?, ?, ?, ?, ?)"),
+		us.Handle, us.UserId, us.Created, us.LastSeen, us.IP, us.UserAgent)
+	return err
+}
+
+// GetSession returns the session with the given handle
+func (s *SQLSessionIndex) GetSession(handle string) (*UserSession, error) {
+	var us UserSession
+	err := s.db.QueryRow(s.bind("SELECT handle, user_id, created, last_seen, ip, user_agent FROM user_sessions WHERE handle=?"), handle).
+		Scan(&us.Handle, &us.UserId, &us.Created, &us.LastSeen, &us.IP, &us.UserAgent)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &us, nil
+}
+
+// TouchSession records the last use of the session with the given handle
+func (s *SQLSessionIndex) TouchSession(handle string, lastSeen int64) error {
+	_, err := s.db.Exec(s.bind("UPDATE user_sessions SET last_seen=? WHERE handle=?"), lastSeen, handle)
+	return err
+}
+
+// ListSessions returns the sessions of the user, most recently seen first
+func (s *SQLSessionIndex) ListSessions(userId int) ([]*UserSession, error) {
+	rows, err := s.db.Query(s.bind("SELECT handle, user_id, created, last_seen, ip, user_agent FROM user_sessions WHERE user_id=? ORDER BY last_seen DESC"), userId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	sessions := make([]*UserSession, 0)
+	for rows.Next() {
+		var us UserSession
+		if err := rows.Scan(&us.Handle, &us.UserId, &us.Created, &us.LastSeen, &us.IP, &us.UserAgent); err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &us)
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteSession removes the session with the given handle
+func (s *SQLSessionIndex) DeleteSession(handle string) error {
+	_, err := s.db.Exec(s.bind("DELETE FROM user_sessions WHERE handle=?"), handle)
+	return err
+}
+
+// DeleteUserSessions removes every session of the user except the one with handle except
+func (s *SQLSessionIndex) DeleteUserSessions(userId int, except string) error {
+	_, err := s.db.Exec(s.bind("DELETE FROM user_sessions WHERE user_id=? AND handle<>?"), userId, except)
+	return err
+}
+
+// PruneSessions removes the sessions of the user last seen before lastSeen
+func (s *SQLSessionIndex) PruneSessions(userId int, lastSeen int64) error {
+	_, err := s.db.Exec(s.bind("DELETE FROM user_sessions WHERE user_id=? AND last_seen<?"), userId, lastSeen)
+	return err
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore is the UserStore backed by a local SQLite database file
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore returns a UserStore backed by the SQLite database opened by OpenDB.
+// The schema is created by the migrations package.
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+// CreateUser saves a user into the database
+func (s *SQLiteStore) CreateUser(u *User) error {
+	res, err := s.db.Exec("INSERT INTO user (name, email, password) VALUES (?, ?, ?)", u.Name, u.Email, []byte(u.Password))
+	if err != nil {
+		return sqliteError(err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	u.Id = int(id)
+	return nil
+}
+
+// GetUserById returns the user with the given id
+func (s *SQLiteStore) GetUserById(id int) (*User, error) {
+	return s.getUser("SELECT id, email, name, password, session_generation, email_verified, totp_secret, totp_enabled, totp_last_step FROM user WHERE id=?", id)
+}
+
+// GetUserByEmail returns the user associated with given email
+func (s *SQLiteStore) GetUserByEmail(email string) (*User, error) {
+	return s.getUser("SELECT id, email, name, password, session_generation, email_verified, totp_secret, totp_enabled, totp_last_step FROM user WHERE email=?", email)
+}
+
+func (s *SQLiteStore) getUser(query string, arg interface{}) (*User, error) {
+	var user User
+	err := s.db.QueryRow(query, arg).Scan(&user.Id, &user.Email, &user.Name, &user.Password, &user.SessionGeneration, &user.EmailVerified, &user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// UpdateUser overwrites the name, email and password of the user having u.Id
+func (s *SQLiteStore) UpdateUser(u *User) error {
+	res, err := s.db.Exec("UPDATE user SET name=?, email=?, password=? WHERE id=?", u.Name, u.Email, []byte(u.Password), u.Id)
+	if err != nil {
+		return sqliteError(err)
+	}
+
+	return checkAffected(res)
+}
+
+// IncrementSessionGeneration invalidates every session of the user with the given id
+func (s *SQLiteStore) IncrementSessionGeneration(id int) error {
+	res, err := s.db.Exec("UPDATE user SET session_generation=session_generation+1 WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// SetEmailVerified records that the user with the given id proved to own their email
+func (s *SQLiteStore) SetEmailVerified(id int) error {
+	res, err := s.db.Exec("UPDATE user SET email_verified=1 WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// SetTOTP stores the TOTP secret of the user with the given id, enabled once confirmed, and forgets the last used code. A nil secret disables TOTP.
+func (s *SQLiteStore) SetTOTP(id int, secret []byte, enabled bool) error {
+	res, err := s.db.Exec("UPDATE user SET totp_secret=?, totp_enabled=?, totp_last_step=0 WHERE id=?", secret, enabled, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// UseTOTPStep records that the TOTP code of the given time step was used, or returns ErrCodeUsed when a code of that step or a later one already was
+func (s *SQLiteStore) UseTOTPStep(id int, step int64) error {
+	res, err := s.db.Exec("UPDATE user SET totp_last_step=? WHERE id=? AND totp_last_step<?", step, id, step)
+	if err != nil {
+		return err
+	}
+
+	return checkUsed(res)
+}
+
+// SetRecoveryCodes replaces the recovery codes of the user with the given hashes
+func (s *SQLiteStore) SetRecoveryCodes(userId int, hashes []string) error {
+	return setRecoveryCodes(s.db, "DELETE FROM user_recovery_codes WHERE user_id=?", "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)", userId, hashes)
+}
+
+// UseRecoveryCode deletes the recovery code of the user with the given hash, or returns ErrCodeUsed when there is none
+func (s *SQLiteStore) UseRecoveryCode(userId int, hash string) error {
+	res, err := s.db.Exec("DELETE FROM user_recovery_codes WHERE user_id=? AND code_hash=?", userId, hash)
+	if err != nil {
+		return err
+	}
+
+	return checkUsed(res)
+}
+
+// CreateResetToken saves the hash of a password reset token of the user, valid until expires, and drops the expired ones
+func (s *SQLiteStore) CreateResetToken(userId int, tokenHash string, expires int64) error {
+	return createResetToken(s.db, "DELETE FROM password_resets WHERE expires<=?", "INSERT INTO password_resets (token_hash, user_id, expires) VALUES (?, ?, ?)",
+		userId, tokenHash, expires, time.Now().Unix())
+}
+
+// ConsumeResetToken returns the user of the unexpired token with the given hash and deletes every reset token of that user
+func (s *SQLiteStore) ConsumeResetToken(tokenHash string) (int, error) {
+	return consumeResetToken(s.db, "SELECT user_id FROM password_resets WHERE token_hash=? AND expires>?", "DELETE FROM password_resets WHERE token_hash=? AND expires>?", "DELETE FROM password_resets WHERE user_id=?", tokenHash, time.Now().Unix())
+}
+
+// DeleteUser removes the user with the given id
+func (s *SQLiteStore) DeleteUser(id int) error {
+	res, err := s.db.Exec("DELETE FROM user WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// ListUsers returns all the users ordered by id
+func (s *SQLiteStore) ListUsers() ([]*User, error) {
+	rows, err := s.db.Query("SELECT id, email, name, password, session_generation, email_verified, totp_secret, totp_enabled, totp_last_step FROM user ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	users := make([]*User, 0)
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Id, &user.Email, &user.Name, &user.Password, &user.SessionGeneration, &user.EmailVerified, &user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep); err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// sqliteError maps a unique constraint violation to ErrEmailTaken
+func sqliteError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrEmailTaken
+	}
+
+	return err
+}
@@ -0,0 +1,265 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/config"
+	"github.com/vabshere/vernacular-auth/migrations"
+)
+
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	c := config.Default().Database
+	c.Driver, c.Path = "sqlite", filepath.Join(t.TempDir(), "users.db")
+	db, err := OpenDB(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+	m, err := migrations.New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSQLiteStore(db)
+}
+
+func TestSQLiteStoreCreateAndGet(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	u := User{Name: "foo", Email: "foo@bar.com", Password: password("hash")}
+	if err := s.CreateUser(&u); err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Id == 0 {
+		t.Fatalf("CreateUser did not set the id")
+	}
+
+	byEmail, err := s.GetUserByEmail("foo@bar.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byId, err := s.GetUserById(u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, got := range []*User{byEmail, byId} {
+		if got.Id != u.Id || got.Name != "foo" || string(got.Password) != "hash" {
+			t.Errorf("got %+v want %+v", got, u)
+		}
+	}
+
+	if _, err := s.GetUserByEmail("nobody@bar.com"); err != ErrUserNotFound {
+		t.Errorf("got %v want ErrUserNotFound", err)
+	}
+}
+
+func TestSQLiteStoreDuplicateEmail(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	u := User{Name: "foo", Email: "foo@bar.com", Password: password("hash")}
+	if err := s.CreateUser(&u); err != nil {
+		t.Fatal(err)
+	}
+
+	dup := User{Name: "bar", Email: "foo@bar.com", Password: password("hash")}
+	if err := s.CreateUser(&dup); err != ErrEmailTaken {
+		t.Errorf("got %v want ErrEmailTaken", err)
+	}
+}
+
+func TestSQLiteStoreUpdateDeleteList(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	for _, email := range []string{"a@bar.com", "b@bar.com"} {
+		u := User{Name: "foo", Email: email, Password: password("hash")}
+		if err := s.CreateUser(&u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users, err := s.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 2 || users[0].Email != "a@bar.com" {
+		t.Fatalf("got %+v want two users ordered by id", users)
+	}
+
+	users[0].Name = "renamed"
+	if err := s.UpdateUser(users[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, _ := s.GetUserById(users[0].Id); u.Name != "renamed" {
+		t.Errorf("got name %q want %q", u.Name, "renamed")
+	}
+
+	if err := s.DeleteUser(users[1].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteUser(users[1].Id); err != ErrUserNotFound {
+		t.Errorf("got %v want ErrUserNotFound", err)
+	}
+}
+
+func TestSQLiteStoreUserFlags(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	u := User{Name: "foo", Email: "foo@bar.com", Password: password("hash")}
+	if err := s.CreateUser(&u); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.IncrementSessionGeneration(u.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := s.GetUserByEmail(u.Email); got.SessionGeneration != 1 {
+		t.Errorf("got generation %d want 1", got.SessionGeneration)
+	}
+
+	if err := s.IncrementSessionGeneration(u.Id + 1); err != ErrUserNotFound {
+		t.Errorf("got %v want ErrUserNotFound", err)
+	}
+
+	if err := s.SetEmailVerified(u.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := s.GetUserById(u.Id); !got.EmailVerified || got.SessionGeneration != 1 {
+		t.Errorf("got %+v want a verified email and the generation kept", got)
+	}
+}
+
+func TestSQLiteStoreResetTokens(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	u := User{Name: "foo", Email: "foo@bar.com", Password: password("hash")}
+	if err := s.CreateUser(&u); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	for hash, expires := range map[string]int64{"expired": now - 1, "first": now + 60, "second": now + 60} {
+		if err := s.CreateResetToken(u.Id, hash, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.ConsumeResetToken("expired"); err != ErrTokenNotFound {
+		t.Errorf("got %v want ErrTokenNotFound for an expired token", err)
+	}
+
+	if id, err := s.ConsumeResetToken("first"); err != nil || id != u.Id {
+		t.Fatalf("got %d, %v want %d", id, err, u.Id)
+	}
+
+	if _, err := s.ConsumeResetToken("second"); err != ErrTokenNotFound {
+		t.Errorf("got %v want the other tokens of the user dropped", err)
+	}
+}
+
+func TestSQLiteStoreTOTP(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	u := User{Name: "foo", Email: "foo@bar.com", Password: password("hash")}
+	if err := s.CreateUser(&u); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetTOTP(u.Id, []byte("secret"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UseTOTPStep(u.Id, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UseTOTPStep(u.Id, 10); err != ErrCodeUsed {
+		t.Errorf("got %v want ErrCodeUsed for a replayed step", err)
+	}
+
+	got, _ := s.GetUserById(u.Id)
+	if string(got.TOTPSecret) != "secret" || !got.TOTPEnabled || got.TOTPLastStep != 10 {
+		t.Errorf("got %+v want the TOTP state stored", got)
+	}
+
+	if err := s.SetRecoveryCodes(u.Id, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UseRecoveryCode(u.Id, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UseRecoveryCode(u.Id, "a"); err != ErrCodeUsed {
+		t.Errorf("got %v want ErrCodeUsed for a used recovery code", err)
+	}
+
+	if err := s.SetTOTP(u.Id, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := s.GetUserById(u.Id); got.TOTPSecret != nil || got.TOTPEnabled {
+		t.Errorf("got %+v want TOTP disabled", got)
+	}
+}
+
+func TestSQLSessionIndex(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	u := User{Name: "foo", Email: "foo@bar.com", Password: password("hash")}
+	if err := s.CreateUser(&u); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := NewSessionIndex("sqlite", s.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, handle := range []string{"a", "b", "c"} {
+		us := UserSession{Handle: handle, UserId: u.Id, Created: 100, LastSeen: int64(100 + i), IP: "127.0.0.1", UserAgent: "test"}
+		if err := index.AddSession(&us); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := index.TouchSession("a", 200); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := index.PruneSessions(u.Id, 102); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := index.ListSessions(u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 || sessions[0].Handle != "a" || sessions[1].Handle != "c" {
+		t.Fatalf("got %+v want a and c, most recently seen first", sessions)
+	}
+
+	if err := index.DeleteUserSessions(u.Id, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := index.GetSession("c"); err != ErrSessionNotFound {
+		t.Errorf("got %v want ErrSessionNotFound", err)
+	}
+
+	if err := s.DeleteUser(u.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := index.GetSession("a"); err != ErrSessionNotFound {
+		t.Errorf("sessions of a deleted user were kept")
+	}
+}
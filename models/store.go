@@ -0,0 +1,69 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/config"
+)
+
+// NewStore returns the UserStore for the configured database driver. db is the shared pool returned by OpenDB and is ignored by the memory driver.
+func NewStore(driver string, db *sql.DB) (UserStore, error) {
+	switch driver {
+	case "mysql":
+		return NewMySQLStore(db), nil
+	case "postgres":
+		return NewPostgresStore(db), nil
+	case "sqlite":
+		return NewSQLiteStore(db), nil
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+
+	return nil, fmt.Errorf("models: unknown database driver %q", driver)
+}
+
+// driverNames maps the configured database drivers to their database/sql driver names
+var driverNames = map[string]string{
+	"mysql":    "mysql",
+	"postgres": "postgres",
+	"sqlite":   "sqlite3",
+}
+
+// OpenDB opens the long-lived connection pool of the configured SQL database and checks it is reachable.
+// The caller owns the pool and closes it on shutdown.
+func OpenDB(c config.Database) (*sql.DB, error) {
+	name, ok := driverNames[c.Driver]
+	if !ok {
+		return nil, fmt.Errorf("models: database driver %q is not an SQL database", c.Driver)
+	}
+
+	db, err := sql.Open(name, c.DSN())
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(c.ConnMaxLifetime) * time.Second)
+	if c.Driver == "sqlite" {
+		// SQLite serializes writers; a single connection avoids "database is locked" errors
+		db.SetMaxOpenConns(1)
+	}
+
+	timeout := time.Duration(c.PingTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("models: connecting to %s database: %w", c.Driver, err)
+	}
+
+	return db, nil
+}
@@ -1,23 +1,13 @@
 package models
 
-import (
-	"database/sql"
+import "errors"
 
-	_ "github.com/go-sql-driver/mysql"
-)
+// ErrUserNotFound is returned by a UserStore when no user matches the lookup
+var ErrUserNotFound = errors.New("models: user not found")
 
-// connectDB connects to the database server
-func connectDb() (*sql.DB, error) {
-	username := "root"
-	password := "mysql"
-	dbName := "basic"
-	db, err := sql.Open("mysql", ""+username+":"+password+"@/"+dbName+"?charset=utf8")
-	if err != nil {
-		return nil, err
-	}
-
-	return db, nil
-}
+// ErrEmailTaken is returned by a UserStore when saving a user whose email already belongs to another user.
+// Every backend maps its own unique violation error to it.
+var ErrEmailTaken = errors.New("models: email already taken")
 
 type password []byte
 
@@ -32,44 +22,47 @@ type User struct {
 	Email    string   `json:"email"`
 	Password password `json:"password"`
 	Id       int      `json:"id"`
+	// SessionGeneration is stored in the sessions of the user, which are only valid while it matches. Incrementing it signs the user out everywhere.
+	SessionGeneration int `json:"-"`
+	// EmailVerified is set once the user followed the verification email
+	EmailVerified bool `json:"email_verified"`
+	// TOTPSecret is the secret of the authenticator app of the user, set on enrollment
+	TOTPSecret []byte `json:"-"`
+	// TOTPEnabled is set once the user confirmed the enrollment with a code. Sign in then needs a code too.
+	TOTPEnabled bool `json:"mfa_enabled"`
+	// TOTPLastStep is the time step of the last TOTP code used, so no code is accepted twice
+	TOTPLastStep int64 `json:"-"`
 }
 
-// SaveUser saves a user into the database
-func SaveUser(u *User) error {
-	db, err := connectDb()
-	if err != nil {
-		return err
-	}
-
-	defer db.Close()
-	stmt, err := db.Prepare("INSERT INTO user (name, email, password) VALUES (?, ?, ?)")
-	if err != nil {
-		return err
-	}
-
-	res, err := stmt.Exec(u.Name, u.Email, u.Password)
-	if err == nil {
-		id, _ := res.LastInsertId()
-		u.Id = int(id)
-	}
-
-	return err
-}
-
-// GetUserByEmail returns the user associated with given email
-func GetUserByEmail(email string) (*User, error) {
-	db, err := connectDb()
-	defer db.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	stmt, err := db.Prepare("SELECT * FROM user WHERE email=?")
-	if err != nil {
-		return nil, err
-	}
-
-	var user User
-	err = stmt.QueryRow(email).Scan(&user.Id, &user.Email, &user.Name, &user.Password)
-	return &user, err
+// UserStore is the interface for all user persistence backends
+type UserStore interface {
+	// CreateUser saves a new user and sets its Id
+	CreateUser(u *User) error
+	// GetUserById returns the user with the given id
+	GetUserById(id int) (*User, error)
+	// GetUserByEmail returns the user associated with given email
+	GetUserByEmail(email string) (*User, error)
+	// UpdateUser overwrites the name, email and password of the stored user having u.Id
+	UpdateUser(u *User) error
+	// IncrementSessionGeneration invalidates every session of the user with the given id, e.g. after a password change
+	IncrementSessionGeneration(id int) error
+	// SetEmailVerified records that the user with the given id proved to own their email
+	SetEmailVerified(id int) error
+	// SetTOTP stores the TOTP secret of the user, enabled once confirmed, and forgets the last used code. A nil secret disables TOTP.
+	SetTOTP(id int, secret []byte, enabled bool) error
+	// UseTOTPStep records that the TOTP code of the given time step was used, or returns ErrCodeUsed when a code of that step or a later one already was
+	UseTOTPStep(id int, step int64) error
+	// SetRecoveryCodes replaces the recovery codes of the user with the given hashes
+	SetRecoveryCodes(userId int, hashes []string) error
+	// UseRecoveryCode deletes the recovery code of the user with the given hash, or returns ErrCodeUsed when there is none
+	UseRecoveryCode(userId int, hash string) error
+	// CreateResetToken saves the hash of a password reset token of the user, valid until the unix time expires
+	CreateResetToken(userId int, tokenHash string, expires int64) error
+	// ConsumeResetToken returns the user of the unexpired reset token with the given hash and deletes every reset token of that user.
+	// It returns ErrTokenNotFound for unknown, expired or already used tokens.
+	ConsumeResetToken(tokenHash string) (int, error)
+	// DeleteUser removes the user with the given id
+	DeleteUser(id int) error
+	// ListUsers returns all the users ordered by id
+	ListUsers() ([]*User, error)
 }
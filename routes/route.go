@@ -3,18 +3,78 @@ package routes
 import (
 	"net/http"
 
+	"github.com/vabshere/vernacular-auth/app"
 	"github.com/vabshere/vernacular-auth/controllers"
 	"github.com/vabshere/vernacular-auth/middleware"
 
 	"github.com/gorilla/mux"
 )
 
-//Init initializes routes for the app
-func Init() *mux.Router {
+// access is who may use a route
+type access int
+
+const (
+	// public routes are open to everyone
+	public access = iota
+	// signedIn routes answer 401 to requests without a signed in user
+	signedIn
+	// verified routes also answer 403 to users who did not verify their email, when auth.verification is restrict
+	verified
+)
+
+// route is an entry of the route table
+type route struct {
+	path    string
+	method  string
+	handler http.Handler
+	access  access
+}
+
+// table returns the route table of the app served by c
+func table(c *controllers.Controller) []route {
+	routes := []route{
+		{"/reg", http.MethodPost, middleware.SessionReset(c.SignUp), public},
+		{"/oauth", http.MethodPost, middleware.SessionReset(c.SignIn), public},
+		{"/home", http.MethodGet, http.HandlerFunc(c.GetUser), signedIn},
+		{"/signOut", http.MethodDelete, http.HandlerFunc(c.SignOut), signedIn},
+		{"/password", http.MethodPost, middleware.SessionReset(c.ChangePassword), verified},
+		{"/password/reset", http.MethodPost, http.HandlerFunc(c.RequestPasswordReset), public},
+		{"/password/reset/confirm", http.MethodPost, http.HandlerFunc(c.ConfirmPasswordReset), public},
+		{"/verify", http.MethodPost, http.HandlerFunc(c.VerifyEmail), public},
+		{"/verify/resend", http.MethodPost, http.HandlerFunc(c.ResendVerification), public},
+		{"/mfa/totp", http.MethodPost, http.HandlerFunc(c.EnrollTOTP), verified},
+		{"/mfa/totp/confirm", http.MethodPost, http.HandlerFunc(c.ConfirmTOTP), verified},
+		{"/mfa/totp/disable", http.MethodPost, http.HandlerFunc(c.DisableTOTP), verified},
+		{"/mfa/verify", http.MethodPost, middleware.SecondFactor(c.VerifyMFA), public},
+		{"/sessions", http.MethodGet, http.HandlerFunc(c.ListSessions), verified},
+		{"/sessions", http.MethodDelete, http.HandlerFunc(c.RevokeOtherSessions), verified},
+		{"/sessions/{id}", http.MethodDelete, http.HandlerFunc(c.RevokeSession), verified},
+	}
+	if c.Config.Server.Metrics {
+		routes = append(routes, route{"/metrics/sessions", http.MethodGet, http.HandlerFunc(c.SessionStats), public})
+	}
+
+	return routes
+}
+
+// Init initializes routes for a and sets them as its Router. Browsers requesting a protected page without a signed in user are redirected to server.login_url, when set.
+func Init(a *app.App) *mux.Router {
 	r := mux.NewRouter()
-	r.Handle("/reg", middleware.SessionReset(controllers.SignUp)).Methods(http.MethodPost)
-	r.Handle("/oauth", middleware.SessionReset(controllers.SignIn)).Methods(http.MethodPost)
-	r.HandleFunc("/home", controllers.GetUser).Methods(http.MethodGet)
-	r.HandleFunc("/signOut", controllers.SignOut).Methods(http.MethodDelete)
+	r.Use(middleware.LoadSession(a.Sessions, a.SessionIndex, a.Users))
+	requireAuth := middleware.RequireAuth(a.Config.Server.LoginURL)
+	for _, rt := range table(controllers.New(a)) {
+		handler := rt.handler
+		if rt.access == verified && a.Config.Auth.Verification == "restrict" {
+			handler = middleware.RequireVerified(handler)
+		}
+
+		if rt.access >= signedIn {
+			handler = requireAuth(handler)
+		}
+
+		r.Handle(rt.path, handler).Methods(rt.method)
+	}
+
+	a.Router = r
 	return r
 }
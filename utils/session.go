@@ -1,32 +1,119 @@
 package utils
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
+
+	"github.com/vabshere/vernacular-auth/config"
 	"github.com/vabshere/vernacular-auth/models"
 	"github.com/vabshere/vernacular-auth/utils/session"
+	"github.com/vabshere/vernacular-auth/utils/session/providers/cookie"
+	"github.com/vabshere/vernacular-auth/utils/session/providers/file"
+	"github.com/vabshere/vernacular-auth/utils/session/providers/memory"
+	"github.com/vabshere/vernacular-auth/utils/session/providers/redis"
+	sqlprovider "github.com/vabshere/vernacular-auth/utils/session/providers/sql"
+
+	goredis "github.com/redis/go-redis/v9"
 )
 
-// GlobalSessions is the global variable for managing sessions
-var GlobalSessions *session.Manager
+// NewSessionManager returns the session manager configured by c. db is the shared pool used by the sql provider, nil without an SQL database.
+func NewSessionManager(c config.Session, db *sql.DB, driver string) (*session.Manager, error) {
+	var provider session.ProviderV2
+	switch c.Provider {
+	case "memory":
+		provider = memory.New(c.MaxLifetime, memory.Limits{MaxSessions: c.Memory.MaxSessions, MaxSessionBytes: c.Memory.MaxSessionBytes})
+	case "redis":
+		client := goredis.NewClient(&goredis.Options{Addr: c.Redis.Addr, Password: c.Redis.Password, DB: c.Redis.DB})
+		provider = redis.New(client, c.Redis.Prefix, c.MaxLifetime)
+	case "sql":
+		if db == nil {
+			return nil, errors.New("utils: the sql session provider needs an SQL database")
+		}
+
+		provider = sqlprovider.New(db, driver, c.MaxLifetime)
+	case "file":
+		p, err := file.New(c.File.Dir, c.MaxLifetime)
+		if err != nil {
+			return nil, err
+		}
+
+		provider = p
+	case "cookie":
+		keys, err := c.Cookie.DecodeKeys()
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := cookie.New(keys, c.CookieName, c.MaxLifetime)
+		if err != nil {
+			return nil, err
+		}
+
+		provider = p
+	}
+
+	var manager *session.Manager
+	var err error
+	if provider != nil {
+		manager, err = session.New(provider, c.CookieName, c.MaxLifetime)
+	} else {
+		manager, err = session.NewManager(c.Provider, c.CookieName, c.MaxLifetime)
+	}
+
+	if err != nil {
+		return nil, err
+	}
 
-// Run initializes the one time configurations required for using sessions
-func Run() {
-	GlobalSessions, _ = session.NewManager("memory", "gosessionid", 3600)
-	go GlobalSessions.GC()
+	if c.Codec == "json" {
+		manager.SetCodec(session.JSONCodec{})
+	}
+
+	manager.SetExpiryPolicy(session.ExpiryPolicy{IdleTimeout: c.MaxLifetime, AbsoluteLifetime: c.AbsoluteLifetime, RefreshCookie: c.RefreshCookie})
+	return manager, nil
 }
 
 // SessionSetUser is used for setting given user's details in given session
-func SessionSetUser(user *models.User, session *session.Session, r *http.Request) {
-	(*session).Set("id", user.Id)
-	(*session).Set("name", user.Name)
-	(*session).Set("email", user.Email)
+func SessionSetUser(user *models.User, session session.SessionV2, r *http.Request) error {
+	ctx := r.Context()
+	if err := session.Set(ctx, "id", user.Id); err != nil {
+		return err
+	}
+
+	if err := session.Set(ctx, "name", user.Name); err != nil {
+		return err
+	}
+
+	if err := session.Set(ctx, "email", user.Email); err != nil {
+		return err
+	}
+
+	return session.Set(ctx, "generation", user.SessionGeneration)
 }
 
-// SessionGetUser returns user details from given session
-func SessionGetUser(session *session.Session, r *http.Request) *models.User {
-	id := (*session).Get("id").(int)
-	name := (*session).Get("name").(string)
-	email := (*session).Get("email").(string)
-	u := models.User{Id: id, Name: name, Email: email}
-	return &u
+// SessionGetUser returns user details from given session, an error wrapping session.ErrNoValue when no user is signed in
+func SessionGetUser(s session.SessionV2, r *http.Request) (*models.User, error) {
+	ctx := r.Context()
+	id, err := session.GetTyped[int](ctx, s, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := session.GetTyped[string](ctx, s, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := session.GetTyped[string](ctx, s, "email")
+	if err != nil {
+		return nil, err
+	}
+
+	generation, err := session.GetTyped[int](ctx, s, "generation")
+	if err != nil && !errors.Is(err, session.ErrNoValue) {
+		return nil, err
+	}
+
+	u := models.User{Id: id, Name: name, Email: email, SessionGeneration: generation}
+	return &u, nil
 }
@@ -0,0 +1,103 @@
+package session
+
+import "context"
+
+// legacyRegenerator is the Regenerator counterpart for providers written against the original interface
+type legacyRegenerator interface {
+	SessionRegenerate(oldsid, sid string) Session
+}
+
+// Adapt wraps a Provider written against the original interface into a ProviderV2.
+// The wrapped provider cannot report failures, so they surface as ErrNoSession from SessionRead and are otherwise lost.
+// The result is a Regenerator when the provider has a SessionRegenerate(oldsid, sid string) Session method.
+func Adapt(provider Provider) ProviderV2 {
+	if _, ok := provider.(legacyRegenerator); ok {
+		return &regeneratorAdapter{adapter{provider: provider}}
+	}
+	return &adapter{provider: provider}
+}
+
+type adapter struct {
+	provider Provider
+}
+
+type regeneratorAdapter struct {
+	adapter
+}
+
+func (a *regeneratorAdapter) SessionRegenerate(ctx context.Context, oldsid, sid string) (SessionV2, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	session := a.provider.(legacyRegenerator).SessionRegenerate(oldsid, sid)
+	if session == nil {
+		return nil, ErrNoSession
+	}
+	return &sessionAdapter{session: session}, nil
+}
+
+func (a *adapter) SessionInit(ctx context.Context, sid string) (SessionV2, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &sessionAdapter{session: a.provider.SessionInit(sid)}, nil
+}
+
+func (a *adapter) SessionRead(ctx context.Context, sid string) (SessionV2, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	session := a.provider.SessionRead(sid)
+	if session == nil {
+		return nil, ErrNoSession
+	}
+	return &sessionAdapter{session: session}, nil
+}
+
+func (a *adapter) SessionDestroy(ctx context.Context, sid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.provider.SessionDestroy(sid)
+	return nil
+}
+
+func (a *adapter) SessionGC(ctx context.Context, maxlifetime int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.provider.SessionGC(maxlifetime)
+	return nil
+}
+
+// sessionAdapter wraps a Session into a SessionV2
+type sessionAdapter struct {
+	session Session
+}
+
+func (s *sessionAdapter) Set(ctx context.Context, key, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.session.Set(key, value)
+	return nil
+}
+
+func (s *sessionAdapter) Get(ctx context.Context, key interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.session.Get(key), nil
+}
+
+func (s *sessionAdapter) Delete(ctx context.Context, key interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.session.Delete(key)
+	return nil
+}
+
+func (s *sessionAdapter) SessionId() string {
+	return s.session.SessionId()
+}
@@ -0,0 +1,266 @@
+package cookie
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/utils/session"
+)
+
+// MaxCookieSize is the largest cookie, name and value included, every browser is required to store
+const MaxCookieSize = 4096
+
+// tokenVersion prefixes every sealed token so the format can change later
+const tokenVersion = 1
+
+// keyIdSize is the number of bytes identifying the key a token was sealed with
+const keyIdSize = 4
+
+var errInvalidToken = errors.New("session/cookie: invalid token")
+
+// ErrTooLarge is returned when a write would grow the sealed session past MaxCookieSize. The session is left unchanged.
+var ErrTooLarge = errors.New("session/cookie: session does not fit in a cookie")
+
+// SessionStore is a session whose values travel sealed inside the cookie. Its id is the sealed token and changes with every write.
+type SessionStore struct {
+	provider *Provider
+	lock     sync.Mutex
+	token    string
+	value    map[interface{}]interface{}
+}
+
+// Set sets key value pair
+func (st *SessionStore) Set(ctx context.Context, key, value interface{}) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	values := make(map[interface{}]interface{}, len(st.value)+1)
+	for k, v := range st.value {
+		values[k] = v
+	}
+
+	values[key] = value
+	return st.update(values)
+}
+
+// Get returns value property corresponding to key
+func (st *SessionStore) Get(ctx context.Context, key interface{}) (interface{}, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if v, ok := st.value[key]; ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+// Delete removes a key, value pair
+func (st *SessionStore) Delete(ctx context.Context, key interface{}) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	values := make(map[interface{}]interface{}, len(st.value))
+	for k, v := range st.value {
+		if k != key {
+			values[k] = v
+		}
+	}
+
+	return st.update(values)
+}
+
+// SessionId returns the sealed token
+func (st *SessionStore) SessionId() string {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return st.token
+}
+
+// update reseals the session with values at the current time, keeping the previous state if sealing fails. The caller holds st.lock.
+func (st *SessionStore) update(values map[interface{}]interface{}) error {
+	now := time.Now().Unix()
+	token, err := st.provider.seal(now, values)
+	if err != nil {
+		return err
+	}
+
+	st.token = token
+	st.value = values
+	return nil
+}
+
+// key is an AES key along with the id tokens sealed with it carry
+type key struct {
+	id   [keyIdSize]byte
+	aead cipher.AEAD
+}
+
+// Provider keeps the whole session in the client cookie, sealed with AES-GCM, so no server side store is needed.
+// The first key seals new tokens, every key opens them, which lets keys be rotated by prepending a new one and dropping the oldest once its tokens expired.
+// Sessions cannot be destroyed server side, the Manager only clears the cookie.
+type Provider struct {
+	keys        []key
+	cookieName  string
+	maxlifetime int64
+	codec       session.Codec
+}
+
+// New returns a Provider sealing sessions with keys, each 16, 24 or 32 bytes long for AES-128, AES-192 or AES-256.
+// cookieName is needed to keep the cookie within MaxCookieSize. maxlifetime, in seconds, bounds the age of a token from its last write,
+// which the Manager renews on activity, the same idle expiry the server side providers apply.
+func New(keys [][]byte, cookieName string, maxlifetime int) (*Provider, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session/cookie: at least one key is required")
+	}
+
+	provider := &Provider{cookieName: cookieName, maxlifetime: int64(maxlifetime), codec: session.GobCodec{}}
+	for i, k := range keys {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, fmt.Errorf("session/cookie: key %d: %w", i, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("session/cookie: key %d: %w", i, err)
+		}
+
+		sum := sha256.Sum256(k)
+		var id [keyIdSize]byte
+		copy(id[:], sum[:])
+		provider.keys = append(provider.keys, key{id: id, aead: aead})
+	}
+
+	return provider, nil
+}
+
+// SetCodec replaces the default GobCodec used to serialize session values
+func (provider *Provider) SetCodec(codec session.Codec) {
+	provider.codec = codec
+}
+
+// CookieOnly marks Provider as a session.CookieProvider
+func (provider *Provider) CookieOnly() {}
+
+// seal encrypts sealedAt and values into a token: version | key id | nonce | AES-GCM(sealed-at | values).
+// The version and key id are authenticated as additional data.
+func (provider *Provider) seal(sealedAt int64, values map[interface{}]interface{}) (string, error) {
+	data, err := provider.codec.Encode(values)
+	if err != nil {
+		return "", err
+	}
+
+	k := provider.keys[0]
+	plain := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(plain, uint64(sealedAt))
+	plain = append(plain, data...)
+
+	header := append([]byte{tokenVersion}, k.id[:]...)
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := k.aead.Seal(append(append([]byte{}, header...), nonce...), nonce, plain, header)
+	token := base64.RawURLEncoding.EncodeToString(sealed)
+	if size := len(provider.cookieName) + 1 + len(token); size > MaxCookieSize {
+		return "", fmt.Errorf("%w: sealed session takes %d bytes, more than %d", ErrTooLarge, size, MaxCookieSize)
+	}
+
+	return token, nil
+}
+
+// open decrypts a token sealed by seal with any of the keys
+func (provider *Provider) open(token string) (int64, map[interface{}]interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) < 1+keyIdSize || raw[0] != tokenVersion {
+		return 0, nil, errInvalidToken
+	}
+
+	header, rest := raw[:1+keyIdSize], raw[1+keyIdSize:]
+	for _, k := range provider.keys {
+		if string(k.id[:]) != string(header[1:]) {
+			continue
+		}
+
+		if len(rest) < k.aead.NonceSize() {
+			return 0, nil, errInvalidToken
+		}
+
+		nonce, ciphertext := rest[:k.aead.NonceSize()], rest[k.aead.NonceSize():]
+		plain, err := k.aead.Open(nil, nonce, ciphertext, header)
+		if err != nil || len(plain) < 8 {
+			return 0, nil, errInvalidToken
+		}
+
+		values, err := session.Decode(provider.codec, plain[8:])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		return int64(binary.BigEndian.Uint64(plain)), values, nil
+	}
+
+	// sealed with a key since dropped from the set
+	return 0, nil, errInvalidToken
+}
+
+// SessionInit seals an empty session. sid is unused, the token is the id.
+func (provider *Provider) SessionInit(ctx context.Context, sid string) (session.SessionV2, error) {
+	st := &SessionStore{provider: provider}
+	if err := st.update(make(map[interface{}]interface{})); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func (provider *Provider) SessionRead(ctx context.Context, sid string) (session.SessionV2, error) {
+	sealedAt, values, err := provider.open(sid)
+	if err == errInvalidToken {
+		return nil, session.ErrNoSession
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if sealedAt+provider.maxlifetime < time.Now().Unix() {
+		return nil, session.ErrNoSession
+	}
+
+	return &SessionStore{provider: provider, token: sid, value: values}, nil
+}
+
+// SessionRegenerate reseals the session of token oldsid with a fresh nonce. sid is unused.
+// The old token cannot be revoked and stays valid until it expires.
+func (provider *Provider) SessionRegenerate(ctx context.Context, oldsid, sid string) (session.SessionV2, error) {
+	read, err := provider.SessionRead(ctx, oldsid)
+	if err != nil {
+		return nil, err
+	}
+
+	st := read.(*SessionStore)
+	if err := st.update(st.value); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// SessionDestroy is a no-op, the session only exists in the cookie the Manager clears
+func (provider *Provider) SessionDestroy(ctx context.Context, sid string) error {
+	return nil
+}
+
+// SessionGC is a no-op, expired tokens are rejected by SessionRead
+func (provider *Provider) SessionGC(ctx context.Context, maxlifetime int) error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package cookie
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/utils/session"
+)
+
+func newTestProvider(t *testing.T, keys ...[]byte) *Provider {
+	if len(keys) == 0 {
+		keys = [][]byte{bytes.Repeat([]byte{1}, 32)}
+	}
+
+	provider, err := New(keys, "gosessionid", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+func newTestSession(t *testing.T, provider *Provider) session.SessionV2 {
+	s, err := provider.SessionInit(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(context.Background(), "id", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	provider := newTestProvider(t)
+	s := newTestSession(t, provider)
+	if err := s.Set(ctx, "name", "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, "name"); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := provider.SessionRead(ctx, s.SessionId())
+	if err != nil {
+		t.Fatalf("SessionRead could not open a sealed session: %v", err)
+	}
+
+	if id, _ := read.Get(ctx, "id"); id != 42 {
+		t.Errorf("got id %v want 42", id)
+	}
+
+	if name, _ := read.Get(ctx, "name"); name != nil {
+		t.Errorf("deleted key was sealed")
+	}
+}
+
+func TestTamperedToken(t *testing.T) {
+	provider := newTestProvider(t)
+	token := []byte(newTestSession(t, provider).SessionId())
+	token[len(token)-2] ^= 1
+	if _, err := provider.SessionRead(context.Background(), string(token)); err != session.ErrNoSession {
+		t.Errorf("tampered token was accepted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	s := newTestSession(t, newTestProvider(t, oldKey))
+
+	rotated := newTestProvider(t, newKey, oldKey)
+	read, err := rotated.SessionRead(ctx, s.SessionId())
+	if err != nil {
+		t.Fatalf("token sealed with a retired key was rejected")
+	}
+
+	if err := read.Set(ctx, "name", "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newTestProvider(t, newKey).SessionRead(ctx, read.SessionId()); err != nil {
+		t.Errorf("write did not reseal with the primary key")
+	}
+
+	if _, err := newTestProvider(t, newKey).SessionRead(ctx, s.SessionId()); err != session.ErrNoSession {
+		t.Errorf("token sealed with a dropped key was accepted")
+	}
+}
+
+func TestCookieBudget(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSession(t, newTestProvider(t))
+	if err := s.Set(ctx, "blob", strings.Repeat("x", MaxCookieSize)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v want ErrTooLarge", err)
+	}
+
+	if blob, _ := s.Get(ctx, "blob"); blob != nil || len(s.SessionId()) > MaxCookieSize {
+		t.Errorf("session grew past the cookie budget")
+	}
+
+	if id, _ := s.Get(ctx, "id"); id != 42 {
+		t.Errorf("rejected write lost earlier values")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	provider := newTestProvider(t)
+	token, err := provider.seal(time.Now().Unix()-61, make(map[interface{}]interface{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRead(context.Background(), token); err != session.ErrNoSession {
+		t.Errorf("token older than maxlifetime was accepted")
+	}
+}
@@ -0,0 +1,347 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/utils/session"
+)
+
+// tempPrefix marks files still being written, GC skips them until they are stale
+const tempPrefix = ".tmp-"
+
+// lockCount is the number of locks session ids are spread over to serialize writes to the same file
+const lockCount = 64
+
+// SessionStore is a session whose values are kept in one file
+type SessionStore struct {
+	provider *Provider
+	sid      string
+	lock     sync.Mutex
+	value    map[interface{}]interface{}
+}
+
+// Set sets key value pair
+func (st *SessionStore) Set(ctx context.Context, key, value interface{}) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.value[key] = value
+	return st.provider.save(ctx, st)
+}
+
+// Get returns value property corresponding to key
+func (st *SessionStore) Get(ctx context.Context, key interface{}) (interface{}, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if v, ok := st.value[key]; ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+// Delete removes a key, value pair
+func (st *SessionStore) Delete(ctx context.Context, key interface{}) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	delete(st.value, key)
+	return st.provider.save(ctx, st)
+}
+
+// SessionId returns sessionid
+func (st *SessionStore) SessionId() string {
+	return st.sid
+}
+
+// Provider stores every session in its own file under a directory. A file's modification time is the session's last access time.
+type Provider struct {
+	dir         string
+	maxlifetime time.Duration
+	codec       session.Codec
+	locks       [lockCount]sync.Mutex
+}
+
+// New returns a Provider storing sessions in dir, creating it if needed. maxlifetime is in seconds.
+func New(dir string, maxlifetime int) (*Provider, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("session/file: %w", err)
+	}
+
+	return &Provider{dir: dir, maxlifetime: time.Duration(maxlifetime) * time.Second, codec: session.GobCodec{}}, nil
+}
+
+// SetCodec replaces the default GobCodec used to serialize session values
+func (provider *Provider) SetCodec(codec session.Codec) {
+	provider.codec = codec
+}
+
+// path returns the file of sid, or "" when sid could escape the directory.
+// Session ids are URL safe base64, anything else comes from a forged cookie.
+func (provider *Provider) path(sid string) string {
+	if sid == "" || strings.HasPrefix(sid, tempPrefix) {
+		return ""
+	}
+
+	for _, c := range sid {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '=') {
+			return ""
+		}
+	}
+
+	return filepath.Join(provider.dir, sid)
+}
+
+// lock returns the lock serializing the writes, removal and renaming of the file of sid
+func (provider *Provider) lock(sid string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(sid))
+	return &provider.locks[h.Sum32()%lockCount]
+}
+
+// save atomically replaces the file of st with its current values. The caller holds st.lock.
+// Only an existing file is replaced, so a write racing SessionDestroy or SessionRegenerate cannot bring the old id back.
+func (provider *Provider) save(ctx context.Context, st *SessionStore) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	path := provider.path(st.sid)
+	if path == "" {
+		return session.ErrNoSession
+	}
+
+	data, err := provider.codec.Encode(st.value)
+	if err != nil {
+		return fmt.Errorf("session/file: encoding session: %w", err)
+	}
+
+	lock := provider.lock(st.sid)
+	lock.Lock()
+	defer lock.Unlock()
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return session.ErrNoSession
+		}
+
+		return fmt.Errorf("session/file: saving session: %w", err)
+	}
+
+	if err := writeFile(path, data); err != nil {
+		return fmt.Errorf("session/file: saving session: %w", err)
+	}
+
+	return nil
+}
+
+// writeFile writes data to a temporary file in the same directory and renames it over path, so readers never see a partial file
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// SessionInit creates the file of sid, the only write allowed to create one
+func (provider *Provider) SessionInit(ctx context.Context, sid string) (session.SessionV2, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	path := provider.path(sid)
+	if path == "" {
+		return nil, session.ErrNoSession
+	}
+
+	st := &SessionStore{provider: provider, sid: sid, value: make(map[interface{}]interface{})}
+	data, err := provider.codec.Encode(st.value)
+	if err != nil {
+		return nil, fmt.Errorf("session/file: encoding session: %w", err)
+	}
+
+	if err := writeFile(path, data); err != nil {
+		return nil, fmt.Errorf("session/file: creating session: %w", err)
+	}
+
+	return st, nil
+}
+
+func (provider *Provider) SessionRead(ctx context.Context, sid string) (session.SessionV2, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	path := provider.path(sid)
+	if path == "" {
+		return nil, session.ErrNoSession
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, session.ErrNoSession
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("session/file: reading session: %w", err)
+	}
+
+	// an expired file not yet collected by SessionGC is as good as gone
+	if time.Since(info.ModTime()) > provider.maxlifetime {
+		return nil, session.ErrNoSession
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, session.ErrNoSession
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("session/file: reading session: %w", err)
+	}
+
+	values, err := session.Decode(provider.codec, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := provider.SessionUpdate(ctx, sid); err != nil {
+		return nil, err
+	}
+
+	return &SessionStore{provider: provider, sid: sid, value: values}, nil
+}
+
+func (provider *Provider) SessionDestroy(ctx context.Context, sid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	path := provider.path(sid)
+	if path == "" {
+		return nil
+	}
+
+	lock := provider.lock(sid)
+	lock.Lock()
+	defer lock.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("session/file: destroying session: %w", err)
+	}
+
+	return nil
+}
+
+// SessionRegenerate renames the file of oldsid to that of sid, which is atomic within a directory.
+// Only the lock of oldsid is taken, as nothing writes to the fresh id before it is returned.
+func (provider *Provider) SessionRegenerate(ctx context.Context, oldsid, sid string) (session.SessionV2, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	oldpath, path := provider.path(oldsid), provider.path(sid)
+	if oldpath == "" || path == "" {
+		return nil, session.ErrNoSession
+	}
+
+	lock := provider.lock(oldsid)
+	lock.Lock()
+	err := os.Rename(oldpath, path)
+	lock.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, session.ErrNoSession
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("session/file: regenerating session: %w", err)
+	}
+
+	return provider.SessionRead(ctx, sid)
+}
+
+// SessionGC removes every session file, and every temporary file left by a crash, not modified for maxlifetime seconds
+func (provider *Provider) SessionGC(ctx context.Context, maxlifetime int) error {
+	entries, err := os.ReadDir(provider.dir)
+	if err != nil {
+		return fmt.Errorf("session/file: collecting sessions: %w", err)
+	}
+
+	deadline := time.Now().Add(-time.Duration(maxlifetime) * time.Second)
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !e.Type().IsRegular() {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		if info.ModTime().Before(deadline) {
+			lock := provider.lock(e.Name())
+			lock.Lock()
+			err := os.Remove(filepath.Join(provider.dir, e.Name()))
+			lock.Unlock()
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("session/file: collecting sessions: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SessionUpdate refreshes the modification time of the session file
+func (provider *Provider) SessionUpdate(ctx context.Context, sid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	path := provider.path(sid)
+	if path == "" {
+		return session.ErrNoSession
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return session.ErrNoSession
+		}
+
+		return fmt.Errorf("session/file: refreshing session: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/utils/session"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	provider, err := New(t.TempDir(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := provider.SessionInit(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range []error{s.Set(ctx, "id", 42), s.Set(ctx, "name", "foo"), s.Delete(ctx, "name")} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read, err := provider.SessionRead(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := read.Get(ctx, "id"); id != 42 {
+		t.Errorf("got id %v want 42", id)
+	}
+
+	if name, _ := read.Get(ctx, "name"); name != nil {
+		t.Errorf("deleted key was persisted")
+	}
+
+	if err := provider.SessionDestroy(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRead(ctx, "abc"); err != session.ErrNoSession {
+		t.Errorf("got %v reading a destroyed session want ErrNoSession", err)
+	}
+}
+
+func TestSessionGC(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	provider, err := New(dir, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sid := range []string{"old", "new"} {
+		if _, err := provider.SessionInit(ctx, sid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.SessionGC(ctx, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
+		t.Errorf("expired session file was not removed")
+	}
+
+	if _, err := provider.SessionRead(ctx, "new"); err != nil {
+		t.Errorf("live session was collected: %v", err)
+	}
+}
+
+func TestForgedSessionId(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	provider, err := New(filepath.Join(dir, "sessions"), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRead(ctx, "../secret"); err != session.ErrNoSession {
+		t.Errorf("read a file outside the session directory")
+	}
+
+	provider.SessionDestroy(ctx, "../secret")
+	if _, err := os.Stat(filepath.Join(dir, "secret")); err != nil {
+		t.Errorf("destroyed a file outside the session directory")
+	}
+}
+
+func TestSessionRegenerate(t *testing.T) {
+	ctx := context.Background()
+	provider, err := New(t.TempDir(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := provider.SessionInit(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(ctx, "id", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = provider.SessionRegenerate(ctx, "old", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := s.Get(ctx, "id"); s.SessionId() != "new" || id != 42 {
+		t.Errorf("got session %q with id %v want session new with id 42", s.SessionId(), id)
+	}
+
+	if _, err := provider.SessionRead(ctx, "old"); err != session.ErrNoSession {
+		t.Errorf("old session id still works")
+	}
+
+	if _, err := provider.SessionRegenerate(ctx, "missing", "other"); err != session.ErrNoSession {
+		t.Errorf("got %v regenerating a missing session want ErrNoSession", err)
+	}
+}
+
+func TestWriteAfterDestroy(t *testing.T) {
+	ctx := context.Background()
+	provider, err := New(t.TempDir(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sid := range []string{"destroyed", "regenerated"} {
+		if _, err := provider.SessionInit(ctx, sid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	destroyed, err := provider.SessionRead(ctx, "destroyed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regenerated, err := provider.SessionRead(ctx, "regenerated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.SessionDestroy(ctx, "destroyed"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRegenerate(ctx, "regenerated", "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []session.SessionV2{destroyed, regenerated} {
+		if err := s.Set(ctx, "id", 42); err != session.ErrNoSession {
+			t.Errorf("got %v writing session %s after it was gone want ErrNoSession", err, s.SessionId())
+		}
+
+		if _, err := provider.SessionRead(ctx, s.SessionId()); err != session.ErrNoSession {
+			t.Errorf("writing session %s brought it back", s.SessionId())
+		}
+	}
+}
@@ -2,108 +2,299 @@ package memory
 
 import (
 	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
+
 	"github.com/vabshere/vernacular-auth/utils/session"
 )
 
-var provider = &Provider{list: list.New()}
+// shardCount is the number of independently locked maps sessions are spread over
+const shardCount = 32
+
+// ErrTooLarge is returned when a write would grow a session past Limits.MaxSessionBytes. The session is left unchanged.
+var ErrTooLarge = errors.New("session/memory: session too large")
+
+// Limits bounds the memory used by a Provider, 0 meaning unlimited
+type Limits struct {
+	// MaxSessions is the number of sessions kept. Past it the least recently used sessions are evicted.
+	// Each shard holds its share, so the eviction order is only approximately global.
+	MaxSessions int
+	// MaxSessionBytes is the size of the gob encoding of the values of a session. Values must then be gob encodable.
+	MaxSessionBytes int
+}
 
+// SessionStore is a session kept in process memory
 type SessionStore struct {
+	provider     *Provider
+	lock         sync.Mutex
 	sid          string
-	timeAccessed time.Time
+	timeAccessed time.Time // guarded by the lock of the shard holding the session
 	value        map[interface{}]interface{}
 }
 
 // Set sets key value pair
-func (st *SessionStore) Set(key, value interface{}) {
+func (st *SessionStore) Set(ctx context.Context, key, value interface{}) error {
+	st.lock.Lock()
+	if max := st.provider.limits.MaxSessionBytes; max > 0 {
+		values := make(map[interface{}]interface{}, len(st.value)+1)
+		for k, v := range st.value {
+			values[k] = v
+		}
+
+		values[key] = value
+		data, err := session.EncodeValues(values)
+		if err != nil {
+			st.lock.Unlock()
+			return fmt.Errorf("session/memory: measuring session: %w", err)
+		}
+
+		if len(data) > max {
+			st.lock.Unlock()
+			return fmt.Errorf("%w: %d bytes, more than %d", ErrTooLarge, len(data), max)
+		}
+	}
+
 	st.value[key] = value
-	provider.SessionUpdate(st.sid)
-	return
+	st.lock.Unlock()
+	st.provider.touch(st)
+	return nil
 }
 
 // Get returns value property corresponding to key
-func (st *SessionStore) Get(key interface{}) interface{} {
-	provider.SessionUpdate(st.sid)
+func (st *SessionStore) Get(ctx context.Context, key interface{}) (interface{}, error) {
+	st.provider.touch(st)
+	st.lock.Lock()
+	defer st.lock.Unlock()
 	if v, ok := st.value[key]; ok {
-		return v
+		return v, nil
 	}
-	return nil
+	return nil, nil
 }
 
 // Delete removes a key, value pair
-func (st *SessionStore) Delete(key interface{}) {
+func (st *SessionStore) Delete(ctx context.Context, key interface{}) error {
+	st.lock.Lock()
 	delete(st.value, key)
-	provider.SessionUpdate(st.sid)
-	return
+	st.lock.Unlock()
+	st.provider.touch(st)
+	return nil
 }
 
 // SessionId returns sessionid
 func (st *SessionStore) SessionId() string {
+	st.lock.Lock()
+	defer st.lock.Unlock()
 	return st.sid
 }
 
-type Provider struct {
+// shard is one part of the sessions, ordered from the most to the least recently used
+type shard struct {
 	lock     sync.Mutex
 	sessions map[string]*list.Element
 	list     *list.List
 }
 
-func (provider *Provider) SessionInit(sid string) session.Session {
-	provider.lock.Lock()
-	defer provider.lock.Unlock()
-	v := make(map[interface{}]interface{}, 0)
-	newsess := &SessionStore{sid: sid, timeAccessed: time.Now(), value: v}
-	element := provider.list.PushBack(newsess)
-	provider.sessions[sid] = element
-	return newsess
+// Provider keeps sessions in process memory, spread over shards so concurrent requests rarely wait on the same lock.
+// Sessions are lost on restart and not shared between processes, but every Provider is independent.
+type Provider struct {
+	shards      [shardCount]shard
+	maxlifetime time.Duration
+	limits      Limits
+	// shardCap is the number of sessions a shard keeps, 0 for unlimited
+	shardCap  int
+	evictions atomic.Int64
+	hits      atomic.Int64
+	misses    atomic.Int64
 }
 
-func (provider *Provider) SessionRead(sid string) session.Session {
-	if element, ok := provider.sessions[sid]; ok {
-		return element.Value.(*SessionStore)
+// New returns an empty Provider. Sessions not used for maxlifetime seconds are no longer returned, 0 leaves expiry to SessionGC.
+func New(maxlifetime int, limits Limits) *Provider {
+	provider := &Provider{maxlifetime: time.Duration(maxlifetime) * time.Second, limits: limits}
+	if limits.MaxSessions > 0 {
+		provider.shardCap = (limits.MaxSessions + shardCount - 1) / shardCount
 	}
-	return nil
+
+	for i := range provider.shards {
+		provider.shards[i].sessions = make(map[string]*list.Element)
+		provider.shards[i].list = list.New()
+	}
+
+	return provider
+}
+
+// shardIndex returns the index of the shard holding sid
+func shardIndex(sid string) int {
+	h := fnv.New32a()
+	h.Write([]byte(sid))
+	return int(h.Sum32() % shardCount)
+}
+
+// shard returns the shard holding sid
+func (provider *Provider) shard(sid string) *shard {
+	return &provider.shards[shardIndex(sid)]
 }
 
-func (provider *Provider) SessionDestroy(sid string) {
-	if element, ok := provider.sessions[sid]; ok {
-		delete(provider.sessions, sid)
-		provider.list.Remove(element)
+// touch records the use of st, unless it was destroyed or regenerated meanwhile
+func (provider *Provider) touch(st *SessionStore) {
+	sid := st.SessionId()
+	sh := provider.shard(sid)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	if element, ok := sh.sessions[sid]; ok && element.Value == st {
+		st.timeAccessed = time.Now()
+		sh.list.MoveToFront(element)
 	}
-	return
 }
 
-func (provider *Provider) SessionGC(maxlifetime int) {
-	provider.lock.Lock()
-	defer provider.lock.Unlock()
+// evict removes the least recently used sessions of sh past its share of Limits.MaxSessions. The caller holds sh.lock.
+func (provider *Provider) evict(sh *shard) {
+	for provider.shardCap > 0 && sh.list.Len() > provider.shardCap {
+		element := sh.list.Back()
+		sh.list.Remove(element)
+		delete(sh.sessions, element.Value.(*SessionStore).SessionId())
+		provider.evictions.Add(1)
+	}
+}
 
-	for {
-		element := provider.list.Back()
-		if element == nil {
-			break
-		}
+// Stats returns the counters of the provider for monitoring: live sessions, evictions since creation, and reads that found a session (hits) or not (misses)
+func (provider *Provider) Stats() map[string]int64 {
+	var live int64
+	for i := range provider.shards {
+		sh := &provider.shards[i]
+		sh.lock.Lock()
+		live += int64(sh.list.Len())
+		sh.lock.Unlock()
+	}
 
-		if (int(element.Value.(*SessionStore).timeAccessed.Unix()) + maxlifetime) < int(time.Now().Unix()) {
-			provider.list.Remove(element)
-			delete(provider.sessions, element.Value.(*SessionStore).sid)
-		} else {
-			break
-		}
+	return map[string]int64{
+		"live":      live,
+		"evictions": provider.evictions.Load(),
+		"hits":      provider.hits.Load(),
+		"misses":    provider.misses.Load(),
 	}
 }
 
-func (provider *Provider) SessionUpdate(sid string) {
-	provider.lock.Lock()
-	defer provider.lock.Unlock()
-	if element, ok := provider.sessions[sid]; ok {
-		element.Value.(*SessionStore).timeAccessed = time.Now()
-		provider.list.MoveToFront(element)
+// expired reports whether st outlived maxlifetime. The caller holds the lock of its shard.
+func (provider *Provider) expired(st *SessionStore, now time.Time) bool {
+	return provider.maxlifetime > 0 && now.Sub(st.timeAccessed) > provider.maxlifetime
+}
+
+func (provider *Provider) SessionInit(ctx context.Context, sid string) (session.SessionV2, error) {
+	st := &SessionStore{provider: provider, sid: sid, timeAccessed: time.Now(), value: make(map[interface{}]interface{})}
+	sh := provider.shard(sid)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	if element, ok := sh.sessions[sid]; ok {
+		sh.list.Remove(element)
 	}
-	return
+
+	sh.sessions[sid] = sh.list.PushFront(st)
+	provider.evict(sh)
+	return st, nil
+}
+
+func (provider *Provider) SessionRead(ctx context.Context, sid string) (session.SessionV2, error) {
+	sh := provider.shard(sid)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	element, ok := sh.sessions[sid]
+	if !ok {
+		provider.misses.Add(1)
+		return nil, session.ErrNoSession
+	}
+
+	st := element.Value.(*SessionStore)
+	now := time.Now()
+	if provider.expired(st, now) {
+		delete(sh.sessions, sid)
+		sh.list.Remove(element)
+		provider.misses.Add(1)
+		return nil, session.ErrNoSession
+	}
+
+	provider.hits.Add(1)
+	st.timeAccessed = now
+	sh.list.MoveToFront(element)
+	return st, nil
+}
+
+func (provider *Provider) SessionDestroy(ctx context.Context, sid string) error {
+	sh := provider.shard(sid)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	if element, ok := sh.sessions[sid]; ok {
+		delete(sh.sessions, sid)
+		sh.list.Remove(element)
+	}
+	return nil
+}
+
+// SessionRegenerate moves the session under oldsid to sid, keeping its values
+func (provider *Provider) SessionRegenerate(ctx context.Context, oldsid, sid string) (session.SessionV2, error) {
+	from, to := provider.shard(oldsid), provider.shard(sid)
+	// Lock both shards in index order so concurrent regenerations cannot deadlock
+	first, second := from, to
+	if shardIndex(sid) < shardIndex(oldsid) {
+		first, second = second, first
+	}
+
+	first.lock.Lock()
+	defer first.lock.Unlock()
+	if second != first {
+		second.lock.Lock()
+		defer second.lock.Unlock()
+	}
+
+	element, ok := from.sessions[oldsid]
+	if !ok || provider.expired(element.Value.(*SessionStore), time.Now()) {
+		return nil, session.ErrNoSession
+	}
+
+	st := element.Value.(*SessionStore)
+	delete(from.sessions, oldsid)
+	from.list.Remove(element)
+	if old, ok := to.sessions[sid]; ok {
+		to.list.Remove(old)
+	}
+
+	st.lock.Lock()
+	st.sid = sid
+	st.lock.Unlock()
+	st.timeAccessed = time.Now()
+	to.sessions[sid] = to.list.PushFront(st)
+	provider.evict(to)
+	return st, nil
+}
+
+func (provider *Provider) SessionGC(ctx context.Context, maxlifetime int) error {
+	deadline := time.Now().Add(-time.Duration(maxlifetime) * time.Second)
+	for i := range provider.shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		sh := &provider.shards[i]
+		sh.lock.Lock()
+		for element := sh.list.Back(); element != nil; element = sh.list.Back() {
+			st := element.Value.(*SessionStore)
+			if !st.timeAccessed.Before(deadline) {
+				break
+			}
+
+			sh.list.Remove(element)
+			delete(sh.sessions, st.SessionId())
+		}
+		sh.lock.Unlock()
+	}
+
+	return nil
 }
 
 func init() {
-	provider.sessions = make(map[string]*list.Element, 0)
-	session.Register("memory", provider)
+	session.RegisterV2("memory", New(0, Limits{}))
 }
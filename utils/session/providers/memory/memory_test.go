@@ -0,0 +1,159 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/utils/session"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	provider := New(60, Limits{})
+	s, err := provider.SessionInit(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(ctx, "id", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := provider.SessionRead(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := read.Get(ctx, "id"); id != 42 {
+		t.Errorf("got id %v want 42", id)
+	}
+
+	if _, err := New(60, Limits{}).SessionRead(ctx, "abc"); err != session.ErrNoSession {
+		t.Errorf("a session leaked into another provider")
+	}
+
+	s, err = provider.SessionRegenerate(ctx, "abc", "def")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := s.Get(ctx, "id"); s.SessionId() != "def" || id != 42 {
+		t.Errorf("got session %q with id %v want session def with id 42", s.SessionId(), id)
+	}
+
+	if _, err := provider.SessionRead(ctx, "abc"); err != session.ErrNoSession {
+		t.Errorf("old session id still works")
+	}
+
+	if err := provider.SessionDestroy(ctx, "def"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRead(ctx, "def"); err != session.ErrNoSession {
+		t.Errorf("destroyed session still works")
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	ctx := context.Background()
+	provider := New(60, Limits{})
+	provider.SessionInit(ctx, "old")
+	provider.SessionInit(ctx, "new")
+	sh := provider.shard("old")
+	sh.lock.Lock()
+	sh.sessions["old"].Value.(*SessionStore).timeAccessed = time.Now().Add(-2 * time.Minute)
+	sh.lock.Unlock()
+
+	if err := provider.SessionGC(ctx, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRead(ctx, "old"); err != session.ErrNoSession {
+		t.Errorf("expired session survived GC")
+	}
+
+	if _, err := provider.SessionRead(ctx, "new"); err != nil {
+		t.Errorf("live session was collected: %v", err)
+	}
+}
+
+// TestConcurrentUse is meant to be run with -race
+func TestConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	provider := New(60, Limits{})
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				sid := strconv.Itoa(i % 20)
+				s, err := provider.SessionRead(ctx, sid)
+				if err == session.ErrNoSession {
+					s, err = provider.SessionInit(ctx, sid)
+				}
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				s.Set(ctx, g, i)
+				s.Get(ctx, g)
+				s.Delete(ctx, g)
+				switch i % 10 {
+				case 0:
+					provider.SessionRegenerate(ctx, sid, strconv.Itoa(i%20+20))
+				case 5:
+					provider.SessionDestroy(ctx, sid)
+				case 7:
+					provider.SessionGC(ctx, 60)
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	provider := New(60, Limits{MaxSessions: shardCount, MaxSessionBytes: 256})
+	for i := 0; i < 10*shardCount; i++ {
+		if _, err := provider.SessionInit(ctx, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := provider.Stats()
+	if stats["live"] > shardCount || stats["evictions"] != 10*shardCount-stats["live"] {
+		t.Errorf("got stats %v want at most %d live sessions and the rest evicted", stats, shardCount)
+	}
+
+	last := strconv.Itoa(10*shardCount - 1)
+	s, err := provider.SessionRead(ctx, last)
+	if err != nil {
+		t.Fatalf("most recent session was evicted: %v", err)
+	}
+
+	provider.SessionRead(ctx, "missing")
+	if stats := provider.Stats(); stats["hits"] != 1 || stats["misses"] != 1 {
+		t.Errorf("got stats %v want one hit and one miss", stats)
+	}
+
+	if err := s.Set(ctx, "name", "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(ctx, "bio", string(make([]byte, 512))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v want ErrTooLarge", err)
+	}
+
+	if bio, _ := s.Get(ctx, "bio"); bio != nil {
+		t.Errorf("rejected value was stored")
+	}
+}
@@ -0,0 +1,182 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/utils/session"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// SessionStore is a session whose values are kept in a single Redis key
+type SessionStore struct {
+	provider *Provider
+	sid      string
+	lock     sync.Mutex
+	value    map[interface{}]interface{}
+}
+
+// Set sets key value pair
+func (st *SessionStore) Set(ctx context.Context, key, value interface{}) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.value[key] = value
+	return st.provider.save(ctx, st)
+}
+
+// Get returns value property corresponding to key
+func (st *SessionStore) Get(ctx context.Context, key interface{}) (interface{}, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if v, ok := st.value[key]; ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+// Delete removes a key, value pair
+func (st *SessionStore) Delete(ctx context.Context, key interface{}) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	delete(st.value, key)
+	return st.provider.save(ctx, st)
+}
+
+// SessionId returns sessionid
+func (st *SessionStore) SessionId() string {
+	return st.sid
+}
+
+// Provider stores sessions in Redis. Every session is one key holding its encoded values and expiring maxlifetime after its last access, so SessionGC has nothing to do.
+type Provider struct {
+	client      goredis.UniversalClient
+	prefix      string
+	maxlifetime time.Duration
+	codec       session.Codec
+}
+
+// New returns a Provider storing sessions through client under keys starting with prefix. maxlifetime is in seconds.
+func New(client goredis.UniversalClient, prefix string, maxlifetime int) *Provider {
+	return &Provider{client: client, prefix: prefix, maxlifetime: time.Duration(maxlifetime) * time.Second, codec: session.GobCodec{}}
+}
+
+// SetCodec replaces the default GobCodec used to serialize session values
+func (provider *Provider) SetCodec(codec session.Codec) {
+	provider.codec = codec
+}
+
+// Close closes the client passed to New
+func (provider *Provider) Close(ctx context.Context) error {
+	return provider.client.Close()
+}
+
+func (provider *Provider) key(sid string) string {
+	return provider.prefix + sid
+}
+
+// encode serializes the values of st. The caller holds st.lock.
+func (provider *Provider) encode(st *SessionStore) ([]byte, error) {
+	data, err := provider.codec.Encode(st.value)
+	if err != nil {
+		return nil, fmt.Errorf("session/redis: encoding session: %w", err)
+	}
+
+	return data, nil
+}
+
+// save writes the values of st and resets its expiry. The caller holds st.lock.
+// Only existing keys are written, so a write racing SessionDestroy or SessionRegenerate cannot bring the old id back.
+func (provider *Provider) save(ctx context.Context, st *SessionStore) error {
+	data, err := provider.encode(st)
+	if err != nil {
+		return err
+	}
+
+	saved, err := provider.client.SetXX(ctx, provider.key(st.sid), data, provider.maxlifetime).Result()
+	if err != nil {
+		return fmt.Errorf("session/redis: saving session: %w", err)
+	}
+
+	if !saved {
+		return session.ErrNoSession
+	}
+
+	return nil
+}
+
+// SessionInit creates the key of sid, the only write allowed to create one
+func (provider *Provider) SessionInit(ctx context.Context, sid string) (session.SessionV2, error) {
+	st := &SessionStore{provider: provider, sid: sid, value: make(map[interface{}]interface{})}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	data, err := provider.encode(st)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := provider.client.Set(ctx, provider.key(sid), data, provider.maxlifetime).Err(); err != nil {
+		return nil, fmt.Errorf("session/redis: creating session: %w", err)
+	}
+
+	return st, nil
+}
+
+func (provider *Provider) SessionRead(ctx context.Context, sid string) (session.SessionV2, error) {
+	data, err := provider.client.GetEx(ctx, provider.key(sid), provider.maxlifetime).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, session.ErrNoSession
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("session/redis: reading session: %w", err)
+	}
+
+	values, err := session.Decode(provider.codec, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionStore{provider: provider, sid: sid, value: values}, nil
+}
+
+func (provider *Provider) SessionDestroy(ctx context.Context, sid string) error {
+	if err := provider.client.Del(ctx, provider.key(sid)).Err(); err != nil {
+		return fmt.Errorf("session/redis: destroying session: %w", err)
+	}
+
+	return nil
+}
+
+// SessionRegenerate renames the key of oldsid to that of sid. RENAME is atomic and keeps the expiry.
+func (provider *Provider) SessionRegenerate(ctx context.Context, oldsid, sid string) (session.SessionV2, error) {
+	renamed, err := provider.client.RenameNX(ctx, provider.key(oldsid), provider.key(sid)).Result()
+	if err != nil {
+		if noSuchKey(err) {
+			return nil, session.ErrNoSession
+		}
+
+		return nil, fmt.Errorf("session/redis: regenerating session: %w", err)
+	}
+
+	if !renamed {
+		return nil, errors.New("session/redis: regenerating session: new session id already in use")
+	}
+
+	return provider.SessionRead(ctx, sid)
+}
+
+// noSuchKey reports whether err is the reply of Redis to renaming a missing key
+func noSuchKey(err error) bool {
+	var reply goredis.Error
+	return errors.As(err, &reply) && strings.HasPrefix(reply.Error(), "ERR no such key")
+}
+
+// SessionGC is a no-op, Redis expires idle sessions by itself
+func (provider *Provider) SessionGC(ctx context.Context, maxlifetime int) error {
+	return nil
+}
@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/vabshere/vernacular-auth/utils/session"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return New(client, "session:", 60), mr
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	provider, mr := newTestProvider(t)
+	s, err := provider.SessionInit(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range []error{s.Set(ctx, "id", 42), s.Set(ctx, "name", "foo"), s.Delete(ctx, "name")} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !mr.Exists("session:abc") {
+		t.Fatalf("session was not written to redis")
+	}
+
+	read, err := provider.SessionRead(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := read.Get(ctx, "id"); id != 42 {
+		t.Errorf("got id %v want 42", id)
+	}
+
+	if name, _ := read.Get(ctx, "name"); name != nil {
+		t.Errorf("deleted key was persisted")
+	}
+
+	if err := provider.SessionDestroy(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRead(ctx, "abc"); err != session.ErrNoSession {
+		t.Errorf("got %v reading a destroyed session want ErrNoSession", err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	ctx := context.Background()
+	provider, mr := newTestProvider(t)
+	if _, err := provider.SessionInit(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	mr.FastForward(30 * time.Second)
+	if _, err := provider.SessionRead(ctx, "abc"); err != nil {
+		t.Fatalf("session expired before its lifetime: %v", err)
+	}
+
+	// reading slid the expiry forward
+	mr.FastForward(45 * time.Second)
+	if _, err := provider.SessionRead(ctx, "abc"); err != nil {
+		t.Fatalf("reading did not refresh the session expiry: %v", err)
+	}
+
+	mr.FastForward(61 * time.Second)
+	if _, err := provider.SessionRead(ctx, "abc"); err != session.ErrNoSession {
+		t.Errorf("idle session did not expire")
+	}
+}
+
+func TestWriteAfterDestroy(t *testing.T) {
+	ctx := context.Background()
+	provider, mr := newTestProvider(t)
+	s, err := provider.SessionInit(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.SessionDestroy(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(ctx, "id", 42); err != session.ErrNoSession {
+		t.Errorf("got %v writing a destroyed session want ErrNoSession", err)
+	}
+
+	if mr.Exists("session:abc") {
+		t.Errorf("writing a destroyed session recreated its key")
+	}
+}
+
+func TestUnreachableServer(t *testing.T) {
+	provider, mr := newTestProvider(t)
+	mr.Close()
+	if _, err := provider.SessionRead(context.Background(), "abc"); err == nil || err == session.ErrNoSession {
+		t.Errorf("got %v want the connection error", err)
+	}
+}
+
+func TestSessionRegenerate(t *testing.T) {
+	ctx := context.Background()
+	provider, _ := newTestProvider(t)
+	s, err := provider.SessionInit(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(ctx, "id", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = provider.SessionRegenerate(ctx, "old", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := s.Get(ctx, "id"); s.SessionId() != "new" || id != 42 {
+		t.Errorf("got session %q with id %v want session new with id 42", s.SessionId(), id)
+	}
+
+	if _, err := provider.SessionRead(ctx, "old"); err != session.ErrNoSession {
+		t.Errorf("old session id still works")
+	}
+
+	if _, err := provider.SessionRegenerate(ctx, "missing", "other"); err != session.ErrNoSession {
+		t.Errorf("got %v regenerating a missing session want ErrNoSession", err)
+	}
+}
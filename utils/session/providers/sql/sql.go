@@ -0,0 +1,175 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/utils/session"
+	"github.com/vabshere/vernacular-auth/utils/sqlbind"
+)
+
+// SessionStore is a session whose values are kept in a row of the sessions table
+type SessionStore struct {
+	provider *Provider
+	sid      string
+	lock     sync.Mutex
+	value    map[interface{}]interface{}
+}
+
+// Set sets key value pair
+func (st *SessionStore) Set(ctx context.Context, key, value interface{}) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.value[key] = value
+	return st.provider.save(ctx, st)
+}
+
+// Get returns value property corresponding to key
+func (st *SessionStore) Get(ctx context.Context, key interface{}) (interface{}, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if v, ok := st.value[key]; ok {
+		return v, nil
+	}
+	return nil, nil
+}
+
+// Delete removes a key, value pair
+func (st *SessionStore) Delete(ctx context.Context, key interface{}) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	delete(st.value, key)
+	return st.provider.save(ctx, st)
+}
+
+// SessionId returns sessionid
+func (st *SessionStore) SessionId() string {
+	return st.sid
+}
+
+// Provider stores sessions in the sessions table created by the migrations package.
+// The access time is refreshed when a session is read or written, not on every Get, to spare the database a write per value read.
+type Provider struct {
+	db          *sql.DB
+	postgres    bool
+	maxlifetime int64
+	codec       session.Codec
+}
+
+// New returns a Provider storing sessions in db. driver is the configured database driver, used to pick the placeholder syntax. maxlifetime is in seconds.
+func New(db *sql.DB, driver string, maxlifetime int) *Provider {
+	return &Provider{db: db, postgres: driver == "postgres", maxlifetime: int64(maxlifetime), codec: session.GobCodec{}}
+}
+
+// SetCodec replaces the default GobCodec used to serialize session values
+func (provider *Provider) SetCodec(codec session.Codec) {
+	provider.codec = codec
+}
+
+// bind rewrites ? placeholders to $n for postgres
+func (provider *Provider) bind(query string) string {
+	return sqlbind.Rebind(provider.postgres, query)
+}
+
+// save writes the values of st and its access time. The caller holds st.lock.
+func (provider *Provider) save(ctx context.Context, st *SessionStore) error {
+	data, err := provider.codec.Encode(st.value)
+	if err != nil {
+		return fmt.Errorf("session/sql: encoding session: %w", err)
+	}
+
+	if _, err := provider.db.ExecContext(ctx, provider.bind("UPDATE sessions SET data = ?, time_accessed = ? WHERE sid = ?"), data, time.Now().Unix(), st.sid); err != nil {
+		return fmt.Errorf("session/sql: saving session: %w", err)
+	}
+
+	return nil
+}
+
+func (provider *Provider) SessionInit(ctx context.Context, sid string) (session.SessionV2, error) {
+	st := &SessionStore{provider: provider, sid: sid, value: make(map[interface{}]interface{})}
+	data, err := provider.codec.Encode(st.value)
+	if err != nil {
+		return nil, fmt.Errorf("session/sql: encoding session: %w", err)
+	}
+
+	if _, err := provider.db.ExecContext(ctx, provider.bind("INSERT INTO sessions (sid, data, time_accessed) VALUES (?, ?, ?)"), sid, data, time.Now().Unix()); err != nil {
+		return nil, fmt.Errorf("session/sql: creating session: %w", err)
+	}
+
+	return st, nil
+}
+
+func (provider *Provider) SessionRead(ctx context.Context, sid string) (session.SessionV2, error) {
+	var data []byte
+	var accessed int64
+	err := provider.db.QueryRowContext(ctx, provider.bind("SELECT data, time_accessed FROM sessions WHERE sid = ?"), sid).Scan(&data, &accessed)
+	if err == sql.ErrNoRows {
+		return nil, session.ErrNoSession
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("session/sql: reading session: %w", err)
+	}
+
+	// an expired row not yet collected by SessionGC is as good as gone
+	now := time.Now().Unix()
+	if accessed+provider.maxlifetime < now {
+		return nil, session.ErrNoSession
+	}
+
+	values, err := session.Decode(provider.codec, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := provider.SessionUpdate(ctx, sid); err != nil {
+		return nil, err
+	}
+
+	return &SessionStore{provider: provider, sid: sid, value: values}, nil
+}
+
+func (provider *Provider) SessionDestroy(ctx context.Context, sid string) error {
+	if _, err := provider.db.ExecContext(ctx, provider.bind("DELETE FROM sessions WHERE sid = ?"), sid); err != nil {
+		return fmt.Errorf("session/sql: destroying session: %w", err)
+	}
+
+	return nil
+}
+
+// SessionRegenerate moves the row of oldsid to sid in a single UPDATE
+func (provider *Provider) SessionRegenerate(ctx context.Context, oldsid, sid string) (session.SessionV2, error) {
+	res, err := provider.db.ExecContext(ctx, provider.bind("UPDATE sessions SET sid = ?, time_accessed = ? WHERE sid = ? AND time_accessed >= ?"), sid, time.Now().Unix(), oldsid, time.Now().Unix()-provider.maxlifetime)
+	if err != nil {
+		return nil, fmt.Errorf("session/sql: regenerating session: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("session/sql: regenerating session: %w", err)
+	} else if n == 0 {
+		return nil, session.ErrNoSession
+	}
+
+	return provider.SessionRead(ctx, sid)
+}
+
+// SessionGC deletes every session idle for longer than maxlifetime seconds in one statement
+func (provider *Provider) SessionGC(ctx context.Context, maxlifetime int) error {
+	if _, err := provider.db.ExecContext(ctx, provider.bind("DELETE FROM sessions WHERE time_accessed < ?"), time.Now().Unix()-int64(maxlifetime)); err != nil {
+		return fmt.Errorf("session/sql: collecting sessions: %w", err)
+	}
+
+	return nil
+}
+
+// SessionUpdate refreshes the access time of the session
+func (provider *Provider) SessionUpdate(ctx context.Context, sid string) error {
+	if _, err := provider.db.ExecContext(ctx, provider.bind("UPDATE sessions SET time_accessed = ? WHERE sid = ?"), time.Now().Unix(), sid); err != nil {
+		return fmt.Errorf("session/sql: refreshing session: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vabshere/vernacular-auth/migrations"
+	"github.com/vabshere/vernacular-auth/utils/session"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *sql.DB) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+	m, err := migrations.New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return New(db, "sqlite", 60), db
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	provider, _ := newTestProvider(t)
+	s, err := provider.SessionInit(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range []error{s.Set(ctx, "id", 42), s.Set(ctx, "name", "foo"), s.Delete(ctx, "name")} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read, err := provider.SessionRead(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := read.Get(ctx, "id"); id != 42 {
+		t.Errorf("got id %v want 42", id)
+	}
+
+	if name, _ := read.Get(ctx, "name"); name != nil {
+		t.Errorf("deleted key was persisted")
+	}
+
+	if err := provider.SessionDestroy(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRead(ctx, "abc"); err != session.ErrNoSession {
+		t.Errorf("got %v reading a destroyed session want ErrNoSession", err)
+	}
+}
+
+func TestSessionGC(t *testing.T) {
+	ctx := context.Background()
+	provider, db := newTestProvider(t)
+	for _, sid := range []string{"old", "new"} {
+		if _, err := provider.SessionInit(ctx, sid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.Exec("UPDATE sessions SET time_accessed = ? WHERE sid = 'old'", time.Now().Unix()-120); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRead(ctx, "old"); err != session.ErrNoSession {
+		t.Errorf("expired session is still readable")
+	}
+
+	if err := provider.SessionGC(ctx, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.SessionRead(ctx, "new"); n != 1 || err != nil {
+		t.Errorf("got %d sessions after GC want only the live one", n)
+	}
+}
+
+func TestSessionRegenerate(t *testing.T) {
+	ctx := context.Background()
+	provider, _ := newTestProvider(t)
+	s, err := provider.SessionInit(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(ctx, "id", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = provider.SessionRegenerate(ctx, "old", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := s.Get(ctx, "id"); s.SessionId() != "new" || id != 42 {
+		t.Errorf("got session %q with id %v want session new with id 42", s.SessionId(), id)
+	}
+
+	if _, err := provider.SessionRead(ctx, "old"); err != session.ErrNoSession {
+		t.Errorf("old session id still works")
+	}
+
+	if _, err := provider.SessionRegenerate(ctx, "missing", "other"); err != session.ErrNoSession {
+		t.Errorf("got %v regenerating a missing session want ErrNoSession", err)
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	ctx := context.Background()
+	provider, _ := newTestProvider(t)
+	provider.SetCodec(session.JSONCodec{})
+	s, err := provider.SessionInit(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set(ctx, "id", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = provider.SessionRead(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := session.GetTyped[int](ctx, s, "id"); err != nil || id != 42 {
+		t.Errorf("got %v, %v want 42", id, err)
+	}
+}
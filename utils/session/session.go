@@ -1,25 +1,57 @@
 package session
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"sync"
 	"time"
 )
 
+// ErrNoSession is returned when the request carries no session or its session no longer exists
+var ErrNoSession = errors.New("session: no session")
+
+// ErrRegenerateUnsupported is returned by Manager.Regenerate when the provider cannot move a session to a new id
+var ErrRegenerateUnsupported = errors.New("session: provider does not support regenerating session ids")
+
+// Keys under which the Manager records when a session was created and last used, as unix seconds
+const (
+	createdKey  = "session.created"
+	accessedKey = "session.accessed"
+)
+
 // Manager is the interface for session manager
 type Manager struct {
 	cookieName  string
-	lock        sync.Mutex
-	provider    Provider
+	lock        sync.Mutex // guards policy and codec, never held across provider calls
+	provider    ProviderV2
 	maxlifetime int
+	policy      ExpiryPolicy
+	codec       Codec
+	stop        chan struct{}
+	closeOnce   sync.Once
+	gc          sync.WaitGroup
+}
+
+// ExpiryPolicy decides when sessions expire. Durations are in seconds, 0 disables the limit.
+type ExpiryPolicy struct {
+	// IdleTimeout expires a session not used for that long
+	IdleTimeout int
+	// AbsoluteLifetime expires a session that long after it was created or regenerated, however active
+	AbsoluteLifetime int
+	// RefreshCookie rewrites the cookie on activity so that it expires along with the session instead of at a fixed time after login
+	RefreshCookie bool
 }
 
-// Provider is the interface for providers
+// Provider is the interface for providers.
+//
+// Deprecated: implement ProviderV2, which reports failures and honors request cancellation. Provider is still accepted by Register through Adapt.
 type Provider interface {
 	SessionInit(sid string) Session
 	SessionRead(sid string) Session
@@ -27,7 +59,9 @@ type Provider interface {
 	SessionGC(maxlifetime int)
 }
 
-// Session is the interface for all the sessions
+// Session is the interface for all the sessions.
+//
+// Deprecated: implement SessionV2.
 type Session interface {
 	Set(key, value interface{})
 	Get(key interface{}) interface{}
@@ -35,19 +69,115 @@ type Session interface {
 	SessionId() string
 }
 
-// NewManager creates a new session manager and returns its pointer reference
+// ProviderV2 is the interface for providers. SessionRead returns ErrNoSession when sid does not name a live session.
+type ProviderV2 interface {
+	SessionInit(ctx context.Context, sid string) (SessionV2, error)
+	SessionRead(ctx context.Context, sid string) (SessionV2, error)
+	SessionDestroy(ctx context.Context, sid string) error
+	SessionGC(ctx context.Context, maxlifetime int) error
+}
+
+// SessionV2 is the interface for all the sessions. Get returns a nil value and no error for a missing key.
+type SessionV2 interface {
+	Set(ctx context.Context, key, value interface{}) error
+	Get(ctx context.Context, key interface{}) (interface{}, error)
+	Delete(ctx context.Context, key interface{}) error
+	SessionId() string
+}
+
+// Regenerator is implemented by providers able to move a session and its values to a new id, atomically invalidating the old one.
+// It returns ErrNoSession when oldsid does not name a live session.
+type Regenerator interface {
+	SessionRegenerate(ctx context.Context, oldsid, sid string) (SessionV2, error)
+}
+
+// Closer is implemented by providers holding connections to release, or writes to flush, when the Manager is closed
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// StatsProvider is implemented by providers keeping counters for monitoring
+type StatsProvider interface {
+	Stats() map[string]int64
+}
+
+// CookieProvider is implemented by providers keeping the whole session inside its cookie.
+// The id of such a session changes whenever its values change, so the cookie must be rewritten with SessionSave.
+type CookieProvider interface {
+	ProviderV2
+	CookieOnly()
+}
+
+// NewManager creates a new session manager using the provider registered under providerName and returns its pointer reference
 func NewManager(providerName, cookieName string, maxlifetime int) (*Manager, error) {
 	provider, ok := provides[providerName]
 	if !ok {
 		return nil, fmt.Errorf("session: unknown provide %q (forgotten import?)", providerName)
 	}
-	return &Manager{provider: provider, cookieName: cookieName, maxlifetime: maxlifetime}, nil
+	return New(provider, cookieName, maxlifetime)
 }
 
-var provides = make(map[string]Provider)
+// New creates a new session manager using provider, which needs no registration, so each instance of the app can own its provider
+func New(provider ProviderV2, cookieName string, maxlifetime int) (*Manager, error) {
+	if maxlifetime <= 0 {
+		return nil, fmt.Errorf("session: maxlifetime must be positive, got %d", maxlifetime)
+	}
+
+	return &Manager{provider: provider, cookieName: cookieName, maxlifetime: maxlifetime, policy: ExpiryPolicy{IdleTimeout: maxlifetime}, codec: GobCodec{}, stop: make(chan struct{})}, nil
+}
 
-// Register creates a session provider with the provided name
+// SetCodec makes the provider serialize session values with codec, when it serializes them at all. It must be called before the first request.
+func (manager *Manager) SetCodec(codec Codec) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.codec = codec
+	if setter, ok := manager.provider.(CodecSetter); ok {
+		setter.SetCodec(codec)
+	}
+}
+
+// Stats returns the counters of the provider, nil when it keeps none
+func (manager *Manager) Stats() map[string]int64 {
+	if provider, ok := manager.provider.(StatsProvider); ok {
+		return provider.Stats()
+	}
+
+	return nil
+}
+
+// Codec returns the codec session values are serialized with
+func (manager *Manager) Codec() Codec {
+	return manager.codec
+}
+
+// Policy returns the expiry policy of the manager
+func (manager *Manager) Policy() ExpiryPolicy {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	return manager.policy
+}
+
+// SetExpiryPolicy replaces the default policy of NewManager, an idle timeout of maxlifetime.
+// Providers must keep sessions for at least policy.IdleTimeout, or policy.AbsoluteLifetime without one, since the Manager only ever shortens their lifetime.
+func (manager *Manager) SetExpiryPolicy(policy ExpiryPolicy) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.policy = policy
+}
+
+var provides = make(map[string]ProviderV2)
+
+// Register creates a session provider with the provided name, adapting it to ProviderV2
 func Register(name string, provider Provider) {
+	if provider == nil {
+		fmt.Println("session: Register provider is nil")
+		return
+	}
+	RegisterV2(name, Adapt(provider))
+}
+
+// RegisterV2 creates a session provider with the provided name
+func RegisterV2(name string, provider ProviderV2) {
 	if provider == nil {
 		fmt.Println("session: Register provider is nil")
 		return
@@ -59,42 +189,254 @@ func Register(name string, provider Provider) {
 	provides[name] = provider
 }
 
-func (manager *Manager) sessionId() string {
+func (manager *Manager) sessionId() (string, error) {
 	b := make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, b); err != nil {
-		return ""
+		return "", fmt.Errorf("session: generating id: %w", err)
 	}
-	return base64.URLEncoding.EncodeToString(b)
+	return base64.URLEncoding.EncodeToString(b), nil
 }
 
 // SessionStart checks if any session associated with the request exists and creates assigns a new one if not present
-func (manager *Manager) SessionStart(w http.ResponseWriter, r *http.Request) Session {
-	manager.lock.Lock()
-	defer manager.lock.Unlock()
-	session, sessionExists := manager.SessionCheck(r)
-	if sessionExists {
-		return session
+func (manager *Manager) SessionStart(w http.ResponseWriter, r *http.Request) (SessionV2, error) {
+	session, err := manager.SessionResume(w, r)
+	if err == nil {
+		return session, nil
+	}
+
+	if !errors.Is(err, ErrNoSession) {
+		return nil, err
+	}
+
+	return manager.SessionNew(w, r)
+}
+
+// SessionNew creates a new session and sets its cookie, ignoring any session the request carries.
+// Ids are random, so concurrent calls need no serialization.
+func (manager *Manager) SessionNew(w http.ResponseWriter, r *http.Request) (SessionV2, error) {
+	sid, err := manager.sessionId()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := manager.provider.SessionInit(r.Context(), sid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := manager.stamp(r.Context(), session, true); err != nil {
+		return nil, err
+	}
+
+	manager.setCookie(w, session.SessionId(), manager.Policy().AbsoluteLifetime)
+	return session, nil
+}
+
+// Regenerate moves the session of the request to a new id, keeping its values, and rewrites the cookie.
+// The old id stops working, which prevents session fixation when privileges change, e.g. on login or password change.
+// The absolute lifetime of the session starts over.
+// Later reads in the same request must use the returned session, as the request still carries the old cookie.
+func (manager *Manager) Regenerate(w http.ResponseWriter, r *http.Request) (SessionV2, error) {
+	oldsid, ok := manager.requestSid(r)
+	if !ok {
+		return nil, ErrNoSession
+	}
+
+	regenerator, ok := manager.provider.(Regenerator)
+	if !ok {
+		return nil, ErrRegenerateUnsupported
+	}
+
+	sid, err := manager.sessionId()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := regenerator.SessionRegenerate(r.Context(), oldsid, sid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := manager.stamp(r.Context(), session, true); err != nil {
+		return nil, err
+	}
+
+	manager.setCookie(w, session.SessionId(), manager.Policy().AbsoluteLifetime)
+	return session, nil
+}
+
+// SessionSave rewrites the cookie of a session whose values changed, when the provider keeps them in the cookie itself
+func (manager *Manager) SessionSave(w http.ResponseWriter, session SessionV2) {
+	if _, ok := manager.provider.(CookieProvider); ok {
+		manager.setCookie(w, session.SessionId(), manager.remaining(session))
+	}
+}
+
+// setCookie sets the session cookie to expire after the idle timeout, or after remaining seconds when sooner and not 0
+func (manager *Manager) setCookie(w http.ResponseWriter, sid string, remaining int) {
+	maxAge := manager.Policy().IdleTimeout
+	if remaining != 0 && (maxAge == 0 || remaining < maxAge) {
+		maxAge = remaining
 	}
-	sid := manager.sessionId()
-	session = manager.provider.SessionInit(sid)
-	cookie := http.Cookie{Name: manager.cookieName, Value: url.QueryEscape(sid), Path: "/", HttpOnly: true, MaxAge: manager.maxlifetime}
+
+	cookie := http.Cookie{Name: manager.cookieName, Value: url.QueryEscape(sid), Path: "/", HttpOnly: true, MaxAge: maxAge}
 	http.SetCookie(w, &cookie)
-	return session
 }
 
-// SessionCheck checks if any session associated with the request exists and returns the session if found
-func (manager *Manager) SessionCheck(r *http.Request) (Session, bool) {
+// stamp records the current time as the last use of session, and as its creation when created is set
+func (manager *Manager) stamp(ctx context.Context, session SessionV2, created bool) error {
+	now := time.Now().Unix()
+	if created {
+		if err := session.Set(ctx, createdKey, now); err != nil {
+			return err
+		}
+	}
+
+	return session.Set(ctx, accessedKey, now)
+}
+
+// timestamp returns the unix time stored under key, or now for sessions started before the Manager recorded it
+func timestamp(ctx context.Context, session SessionV2, key string, now int64) (int64, error) {
+	t, err := GetTyped[int64](ctx, session, key)
+	if errors.Is(err, ErrNoValue) {
+		return now, nil
+	}
+
+	return t, err
+}
+
+// remaining returns the seconds left in the absolute lifetime of session, 0 without one and -1 once over
+func (manager *Manager) remaining(session SessionV2) int {
+	policy := manager.Policy()
+	if policy.AbsoluteLifetime == 0 {
+		return 0
+	}
+
+	now := time.Now().Unix()
+	created, err := timestamp(context.Background(), session, createdKey, now)
+	if err != nil {
+		return policy.AbsoluteLifetime
+	}
+
+	if left := created + int64(policy.AbsoluteLifetime) - now; left > 0 {
+		return int(left)
+	}
+
+	return -1
+}
+
+// expired reports whether session outlived the idle timeout or the absolute lifetime of the policy
+func (manager *Manager) expired(ctx context.Context, session SessionV2) (bool, error) {
+	policy := manager.Policy()
+	now := time.Now().Unix()
+	if policy.AbsoluteLifetime > 0 {
+		created, err := timestamp(ctx, session, createdKey, now)
+		if err != nil {
+			return false, err
+		}
+
+		if now-created >= int64(policy.AbsoluteLifetime) {
+			return true, nil
+		}
+	}
+
+	if policy.IdleTimeout > 0 {
+		accessed, err := timestamp(ctx, session, accessedKey, now)
+		if err != nil {
+			return false, err
+		}
+
+		if now-accessed >= int64(policy.IdleTimeout) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// touchInterval is how often, in seconds, activity is recorded in a session. Recording every request would turn each read into a write.
+func (manager *Manager) touchInterval() int64 {
+	if interval := int64(manager.Policy().IdleTimeout / 10); interval > 1 {
+		return interval
+	}
+
+	return 1
+}
+
+// SessionResume returns the session of the request like SessionCheck and records the activity.
+// Once per touchInterval the last use is stored, renewing the idle timeout, and with RefreshCookie the cookie is rewritten to match.
+// It takes no lock, so requests of different sessions never wait on each other. Concurrent touches of one session store the same timestamp.
+func (manager *Manager) SessionResume(w http.ResponseWriter, r *http.Request) (SessionV2, error) {
+	session, err := manager.SessionCheck(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := r.Context()
+	now := time.Now().Unix()
+	accessed, err := timestamp(ctx, session, accessedKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if now-accessed < manager.touchInterval() {
+		return session, nil
+	}
+
+	if err := manager.stamp(ctx, session, false); err != nil {
+		return nil, err
+	}
+
+	_, cookieOnly := manager.provider.(CookieProvider)
+	if manager.Policy().RefreshCookie || cookieOnly {
+		manager.setCookie(w, session.SessionId(), manager.remaining(session))
+	}
+
+	return session, nil
+}
+
+// requestSid returns the session id carried by the request cookie
+func (manager *Manager) requestSid(r *http.Request) (string, bool) {
 	cookie, err := r.Cookie(manager.cookieName)
 	if err != nil || cookie.Value == "" {
-		return nil, false
-	} else {
-		sid, _ := url.QueryUnescape(cookie.Value)
-		session := manager.provider.SessionRead(sid)
-		if session != nil {
-			return session, true
+		return "", false
+	}
+
+	sid, err := url.QueryUnescape(cookie.Value)
+	if err != nil || sid == "" {
+		return "", false
+	}
+
+	return sid, true
+}
+
+// SessionCheck returns the session associated with the request, ErrNoSession if there is none or it expired, or the error of the provider.
+// Expired sessions are destroyed. Use SessionResume to also record the activity.
+func (manager *Manager) SessionCheck(r *http.Request) (SessionV2, error) {
+	sid, ok := manager.requestSid(r)
+	if !ok {
+		return nil, ErrNoSession
+	}
+
+	session, err := manager.provider.SessionRead(r.Context(), sid)
+	if err != nil {
+		return nil, err
+	}
+
+	expired, err := manager.expired(r.Context(), session)
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		if err := manager.provider.SessionDestroy(r.Context(), sid); err != nil {
+			return nil, err
 		}
-		return session, false
+
+		return nil, ErrNoSession
 	}
+
+	return session, nil
 }
 
 // GetCookie returns the cookie set associated with the request
@@ -106,25 +448,63 @@ func (manager *Manager) GetCookie(r *http.Request) (*http.Cookie, error) {
 	return cookie, nil
 }
 
-// SessionDestroy deletes any existing session associated with the request
-func (manager *Manager) SessionDestroy(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie(manager.cookieName)
-	if err != nil || cookie.Value == "" {
-		return
-	} else {
-		manager.lock.Lock()
-		defer manager.lock.Unlock()
-		manager.provider.SessionDestroy(cookie.Value)
-		expiration := time.Now()
-		cookie := http.Cookie{Name: manager.cookieName, Path: "/", HttpOnly: true, Expires: expiration, MaxAge: -1}
-		http.SetCookie(w, &cookie)
+// SessionDestroy deletes any existing session associated with the request and clears its cookie
+func (manager *Manager) SessionDestroy(w http.ResponseWriter, r *http.Request) error {
+	sid, ok := manager.requestSid(r)
+	if !ok {
+		return nil
 	}
+
+	expiration := time.Now()
+	cookie := http.Cookie{Name: manager.cookieName, Path: "/", HttpOnly: true, Expires: expiration, MaxAge: -1}
+	http.SetCookie(w, &cookie)
+	return manager.provider.SessionDestroy(r.Context(), sid)
 }
 
-// GC deletes sessions after their allowed lifetime
-func (manager *Manager) GC() {
-	manager.lock.Lock()
-	defer manager.lock.Unlock()
-	manager.provider.SessionGC(manager.maxlifetime)
-	time.AfterFunc(time.Duration(manager.maxlifetime), func() { manager.GC() })
+// GC deletes the sessions that outlived their allowed lifetime once
+func (manager *Manager) GC(ctx context.Context) error {
+	return manager.provider.SessionGC(ctx, manager.maxlifetime)
+}
+
+// StartGC runs GC every maxlifetime seconds in the background until Close is called. Failures are logged to logger.
+func (manager *Manager) StartGC(logger *log.Logger) {
+	manager.gc.Add(1)
+	go func() {
+		defer manager.gc.Done()
+		ticker := time.NewTicker(time.Duration(manager.maxlifetime) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := manager.GC(context.Background()); err != nil {
+					logger.Printf("session: collecting expired sessions: %v", err)
+				}
+			case <-manager.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the background GC, waiting for a running collection, and then closes the provider when it is a Closer.
+// It gives up waiting when ctx is done. The Manager must not be used afterwards, and the provider is closed again by every call.
+func (manager *Manager) Close(ctx context.Context) error {
+	manager.closeOnce.Do(func() { close(manager.stop) })
+	done := make(chan struct{})
+	go func() {
+		manager.gc.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if closer, ok := manager.provider.(Closer); ok {
+		return closer.Close(ctx)
+	}
+
+	return nil
 }
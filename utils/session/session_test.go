@@ -0,0 +1,339 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("store is down")
+
+// failingProvider is a ProviderV2 whose store is unreachable
+type failingProvider struct{}
+
+func (failingProvider) SessionInit(ctx context.Context, sid string) (SessionV2, error) {
+	return nil, errDown
+}
+
+func (failingProvider) SessionRead(ctx context.Context, sid string) (SessionV2, error) {
+	return nil, errDown
+}
+
+func (failingProvider) SessionDestroy(ctx context.Context, sid string) error {
+	return errDown
+}
+
+func (failingProvider) SessionGC(ctx context.Context, maxlifetime int) error {
+	return errDown
+}
+
+// legacyProvider is a Provider written against the original interface that never finds a session
+type legacyProvider struct{}
+
+func (legacyProvider) SessionInit(sid string) Session { return nil }
+func (legacyProvider) SessionRead(sid string) Session { return nil }
+func (legacyProvider) SessionDestroy(sid string)      {}
+func (legacyProvider) SessionGC(maxlifetime int)      {}
+
+func requestWithCookie() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "sid", Value: "abc"})
+	return r
+}
+
+func TestManagerPropagatesErrors(t *testing.T) {
+	manager := &Manager{provider: failingProvider{}, cookieName: "sid", maxlifetime: 60}
+	if _, err := manager.SessionCheck(requestWithCookie()); !errors.Is(err, errDown) {
+		t.Errorf("SessionCheck got %v want the provider error", err)
+	}
+
+	if _, err := manager.SessionStart(httptest.NewRecorder(), requestWithCookie()); !errors.Is(err, errDown) {
+		t.Errorf("SessionStart got %v want the provider error", err)
+	}
+
+	if err := manager.SessionDestroy(httptest.NewRecorder(), requestWithCookie()); !errors.Is(err, errDown) {
+		t.Errorf("SessionDestroy got %v want the provider error", err)
+	}
+
+	if _, err := manager.SessionCheck(httptest.NewRequest(http.MethodGet, "/", nil)); err != ErrNoSession {
+		t.Errorf("SessionCheck without a cookie got %v want ErrNoSession", err)
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	provider := Adapt(legacyProvider{})
+	if _, err := provider.SessionRead(context.Background(), "abc"); err != ErrNoSession {
+		t.Errorf("got %v want ErrNoSession for a missing legacy session", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := provider.SessionRead(ctx, "abc"); err != context.Canceled {
+		t.Errorf("got %v want context.Canceled", err)
+	}
+}
+
+// mapSession is a SessionV2 kept in a map
+type mapSession struct {
+	sid   string
+	value map[interface{}]interface{}
+}
+
+func (s *mapSession) Set(ctx context.Context, key, value interface{}) error {
+	s.value[key] = value
+	return nil
+}
+
+func (s *mapSession) Get(ctx context.Context, key interface{}) (interface{}, error) {
+	return s.value[key], nil
+}
+
+func (s *mapSession) Delete(ctx context.Context, key interface{}) error {
+	delete(s.value, key)
+	return nil
+}
+
+func (s *mapSession) SessionId() string {
+	return s.sid
+}
+
+// mapProvider is a Regenerator keeping sessions in a map
+type mapProvider map[string]*mapSession
+
+func (p mapProvider) SessionInit(ctx context.Context, sid string) (SessionV2, error) {
+	p[sid] = &mapSession{sid: sid, value: make(map[interface{}]interface{})}
+	return p[sid], nil
+}
+
+func (p mapProvider) SessionRead(ctx context.Context, sid string) (SessionV2, error) {
+	if s, ok := p[sid]; ok {
+		return s, nil
+	}
+	return nil, ErrNoSession
+}
+
+func (p mapProvider) SessionDestroy(ctx context.Context, sid string) error {
+	delete(p, sid)
+	return nil
+}
+
+func (p mapProvider) SessionGC(ctx context.Context, maxlifetime int) error {
+	return nil
+}
+
+func (p mapProvider) SessionRegenerate(ctx context.Context, oldsid, sid string) (SessionV2, error) {
+	s, ok := p[oldsid]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	delete(p, oldsid)
+	s.sid = sid
+	p[sid] = s
+	return s, nil
+}
+
+func TestRegenerate(t *testing.T) {
+	provider := mapProvider{}
+	manager := &Manager{provider: provider, cookieName: "sid", maxlifetime: 60}
+	old, _ := provider.SessionInit(context.Background(), "abc")
+	old.Set(context.Background(), "id", 42)
+
+	w := httptest.NewRecorder()
+	s, err := manager.Regenerate(w, requestWithCookie())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.SessionId() == "abc" {
+		t.Fatalf("session id was not changed")
+	}
+
+	if id, _ := s.Get(context.Background(), "id"); id != 42 {
+		t.Errorf("got id %v want 42 kept across regeneration", id)
+	}
+
+	if _, err := manager.SessionCheck(requestWithCookie()); err != ErrNoSession {
+		t.Errorf("old session id still works")
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == "abc" {
+		t.Errorf("cookie was not rewritten with the new id: %v", cookies)
+	}
+
+	unsupported := &Manager{provider: failingProvider{}, cookieName: "sid", maxlifetime: 60}
+	if _, err := unsupported.Regenerate(httptest.NewRecorder(), requestWithCookie()); err != ErrRegenerateUnsupported {
+		t.Errorf("got %v want ErrRegenerateUnsupported", err)
+	}
+}
+
+func TestExpiryPolicy(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Unix()
+	cases := []struct {
+		name              string
+		created, accessed int64
+		expired           bool
+	}{
+		{"fresh", now - 10, now - 10, false},
+		{"idle", now - 100, now - 61, true},
+		{"absolute", now - 3600, now - 1, true},
+		{"untracked", 0, 0, false},
+	}
+	for _, c := range cases {
+		provider := mapProvider{}
+		manager := &Manager{provider: provider, cookieName: "sid", maxlifetime: 60}
+		manager.SetExpiryPolicy(ExpiryPolicy{IdleTimeout: 60, AbsoluteLifetime: 600})
+		s, _ := provider.SessionInit(ctx, "abc")
+		if c.created != 0 {
+			s.Set(ctx, createdKey, c.created)
+			s.Set(ctx, accessedKey, c.accessed)
+		}
+
+		_, err := manager.SessionCheck(requestWithCookie())
+		if c.expired && (err != ErrNoSession || provider["abc"] != nil) {
+			t.Errorf("%s: got %v want ErrNoSession and the session destroyed", c.name, err)
+		}
+
+		if !c.expired && err != nil {
+			t.Errorf("%s: got %v want the session", c.name, err)
+		}
+	}
+}
+
+func TestSessionResumeRefreshesCookie(t *testing.T) {
+	ctx := context.Background()
+	provider := mapProvider{}
+	manager := &Manager{provider: provider, cookieName: "sid", maxlifetime: 60}
+	manager.SetExpiryPolicy(ExpiryPolicy{IdleTimeout: 60, AbsoluteLifetime: 600, RefreshCookie: true})
+	s, _ := provider.SessionInit(ctx, "abc")
+	now := time.Now().Unix()
+	s.Set(ctx, createdKey, now-580)
+	s.Set(ctx, accessedKey, now-30)
+
+	w := httptest.NewRecorder()
+	if _, err := manager.SessionResume(w, requestWithCookie()); err != nil {
+		t.Fatal(err)
+	}
+
+	if accessed, _ := s.Get(ctx, accessedKey); accessed.(int64) < now {
+		t.Errorf("activity was not recorded")
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge > 20 || cookies[0].MaxAge <= 0 {
+		t.Errorf("got cookies %v want one expiring with the absolute lifetime in 20 seconds", cookies)
+	}
+
+	w = httptest.NewRecorder()
+	if _, err := manager.SessionResume(w, requestWithCookie()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("cookie rewritten again within the touch interval")
+	}
+}
+
+func TestGetTyped(t *testing.T) {
+	ctx := context.Background()
+	s := &mapSession{sid: "abc", value: make(map[interface{}]interface{})}
+	data, err := JSONCodec{}.Encode(map[interface{}]interface{}{"id": 42, "name": "foo", "ratio": 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.value, err = (JSONCodec{}).Decode(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := GetTyped[int](ctx, s, "id"); err != nil || id != 42 {
+		t.Errorf("got %v, %v want 42 from a JSON number", id, err)
+	}
+
+	if name, err := GetTyped[string](ctx, s, "name"); err != nil || name != "foo" {
+		t.Errorf("got %q, %v want foo", name, err)
+	}
+
+	if _, err := GetTyped[int](ctx, s, "ratio"); err == nil {
+		t.Errorf("0.5 was truncated to an int")
+	}
+
+	if _, err := GetTyped[int](ctx, s, "name"); err == nil {
+		t.Errorf("a string was returned as an int")
+	}
+
+	if _, err := GetTyped[int](ctx, s, "missing"); !errors.Is(err, ErrNoValue) {
+		t.Errorf("got %v want ErrNoValue", err)
+	}
+
+	s.value["small"] = float64(3)
+	if n, err := GetTyped[uint8](ctx, s, "small"); err != nil || n != 3 {
+		t.Errorf("got %v, %v want 3 from a float64", n, err)
+	}
+
+	s.value["big"] = 300
+	if _, err := GetTyped[uint8](ctx, s, "big"); err == nil {
+		t.Errorf("300 overflowed into a uint8")
+	}
+
+	if _, err := (JSONCodec{}).Encode(map[interface{}]interface{}{1: "one"}); err == nil {
+		t.Errorf("JSON codec accepted a non string key")
+	}
+}
+
+// brokenCodec fails to decode anything
+type brokenCodec struct {
+	GobCodec
+}
+
+func (brokenCodec) Decode(data []byte) (map[interface{}]interface{}, error) {
+	return nil, errDown
+}
+
+func TestDecode(t *testing.T) {
+	if _, err := Decode(brokenCodec{}, nil); !errors.Is(err, ErrNoSession) || !errors.Is(err, errDown) {
+		t.Errorf("got %v want ErrNoSession wrapping the decoding error", err)
+	}
+}
+
+// closingProvider is a mapProvider recording whether it was closed
+type closingProvider struct {
+	mapProvider
+	closed bool
+}
+
+func (p *closingProvider) Close(ctx context.Context) error {
+	p.closed = true
+	return nil
+}
+
+func TestNewRejectsMaxlifetime(t *testing.T) {
+	if _, err := New(mapProvider{}, "sid", 0); err == nil {
+		t.Errorf("a maxlifetime of 0 was accepted")
+	}
+}
+
+func TestClose(t *testing.T) {
+	provider := &closingProvider{mapProvider: mapProvider{}}
+	manager, err := New(provider, "sid", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager.StartGC(log.New(io.Discard, "", 0))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := manager.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !provider.closed {
+		t.Errorf("provider was not closed")
+	}
+}
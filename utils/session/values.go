@@ -0,0 +1,209 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// ErrNoValue is returned by GetTyped when the session has no value under the key
+var ErrNoValue = errors.New("session: no value")
+
+// Codec serializes the values of a session for providers persisting them outside the process
+type Codec interface {
+	Encode(values map[interface{}]interface{}) ([]byte, error)
+	Decode(data []byte) (map[interface{}]interface{}, error)
+}
+
+// CodecSetter is implemented by providers serializing session values. The Manager passes them its codec.
+type CodecSetter interface {
+	SetCodec(codec Codec)
+}
+
+// Decode deserializes data stored by a provider with codec. Data the codec cannot read, written with another codec or corrupted, counts as no session:
+// the error wraps ErrNoSession, so the Manager starts a new session, along with the cause, so it can still be logged.
+func Decode(codec Codec, data []byte) (map[interface{}]interface{}, error) {
+	values, err := codec.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding values: %w", ErrNoSession, err)
+	}
+
+	return values, nil
+}
+
+// GobCodec encodes values with encoding/gob, the default. Values of types other than the basic ones must be registered with gob.Register.
+type GobCodec struct{}
+
+// Encode serializes values
+func (GobCodec) Encode(values map[interface{}]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode deserializes values encoded by Encode
+func (GobCodec) Decode(data []byte) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{})
+	if len(data) == 0 {
+		return values, nil
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// JSONCodec encodes values as a JSON object, readable by other languages. Keys must be strings.
+// Numbers decode as json.Number and structs as maps, read them back with GetTyped.
+type JSONCodec struct{}
+
+// Encode serializes values
+func (JSONCodec) Encode(values map[interface{}]interface{}) ([]byte, error) {
+	object := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("session: JSON session keys must be strings, got %T", k)
+		}
+
+		object[key] = v
+	}
+
+	return json.Marshal(object)
+}
+
+// Decode deserializes values encoded by Encode
+func (JSONCodec) Decode(data []byte) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{})
+	if len(data) == 0 {
+		return values, nil
+	}
+
+	object := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+
+	for k, v := range object {
+		values[k] = v
+	}
+
+	return values, nil
+}
+
+// EncodeValues serializes the values of a session with GobCodec
+func EncodeValues(values map[interface{}]interface{}) ([]byte, error) {
+	return GobCodec{}.Encode(values)
+}
+
+// DecodeValues deserializes session values encoded by EncodeValues
+func DecodeValues(data []byte) (map[interface{}]interface{}, error) {
+	return GobCodec{}.Decode(data)
+}
+
+// GetTyped returns the value of key in session as a T. It returns ErrNoValue when the key is missing and an error when the value is of another type.
+// Numbers are converted between numeric types when no precision is lost, since a store may decode an int as float64 or json.Number.
+func GetTyped[T any](ctx context.Context, session SessionV2, key interface{}) (T, error) {
+	var t T
+	v, err := session.Get(ctx, key)
+	if err != nil {
+		return t, err
+	}
+
+	if v == nil {
+		return t, fmt.Errorf("%w for key %v", ErrNoValue, key)
+	}
+
+	if typed, ok := v.(T); ok {
+		return typed, nil
+	}
+
+	if !convertNumber(v, reflect.ValueOf(&t).Elem()) {
+		return t, fmt.Errorf("session: value of key %v is a %T, not a %T", key, v, t)
+	}
+
+	return t, nil
+}
+
+// convertNumber stores the number v in dst when both are numeric and v fits dst exactly
+func convertNumber(v interface{}, dst reflect.Value) bool {
+	var i int64
+	var u uint64
+	var f float64
+	var kind reflect.Kind
+	src := reflect.ValueOf(v)
+	switch {
+	case src.CanInt():
+		i, kind = src.Int(), reflect.Int64
+	case src.CanUint():
+		u, kind = src.Uint(), reflect.Uint64
+	case src.CanFloat():
+		f, kind = src.Float(), reflect.Float64
+	default:
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+
+		var err error
+		if i, err = n.Int64(); err == nil {
+			kind = reflect.Int64
+		} else if f, err = n.Float64(); err == nil {
+			kind = reflect.Float64
+		} else {
+			return false
+		}
+	}
+
+	if kind == reflect.Float64 && f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		i, kind = int64(f), reflect.Int64
+	}
+
+	switch {
+	case dst.CanInt():
+		if kind == reflect.Uint64 && u <= math.MaxInt64 {
+			i, kind = int64(u), reflect.Int64
+		}
+
+		if kind != reflect.Int64 || dst.OverflowInt(i) {
+			return false
+		}
+
+		dst.SetInt(i)
+	case dst.CanUint():
+		if kind == reflect.Int64 && i >= 0 {
+			u, kind = uint64(i), reflect.Uint64
+		}
+
+		if kind != reflect.Uint64 || dst.OverflowUint(u) {
+			return false
+		}
+
+		dst.SetUint(u)
+	case dst.CanFloat():
+		switch kind {
+		case reflect.Int64:
+			f = float64(i)
+		case reflect.Uint64:
+			f = float64(u)
+		}
+
+		dst.SetFloat(f)
+	default:
+		return false
+	}
+
+	return true
+}
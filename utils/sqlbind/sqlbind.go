@@ -0,0 +1,28 @@
+package sqlbind
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Rebind rewrites the ? placeholders of query to $1, $2, ... when postgres is set, and returns query unchanged otherwise.
+// Queries are written with ? for mysql and sqlite, the only placeholder they accept.
+func Rebind(postgres bool, query string) string {
+	if !postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
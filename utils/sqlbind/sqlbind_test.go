@@ -0,0 +1,14 @@
+package sqlbind
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	query := "UPDATE sessions SET data = ? WHERE sid = ?"
+	if got := Rebind(true, query); got != "UPDATE sessions SET data = $1 WHERE sid = $2" {
+		t.Errorf("got %q", got)
+	}
+
+	if got := Rebind(false, query); got != query {
+		t.Errorf("got %q want the query unchanged", got)
+	}
+}
@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRFC6238 checks the SHA-1 test vectors of RFC 6238 appendix B
+func TestRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, want := range vectors {
+		if got := hotp(secret, Step(time.Unix(unix, 0)), 8); got != want {
+			t.Errorf("at %d got %s want %s", unix, got, want)
+		}
+	}
+
+	if got := Code(secret, time.Unix(59, 0)); got != "287082" {
+		t.Errorf("got %s want the last 6 digits 287082", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, drift := range []time.Duration{-Period * time.Second, 0, Period * time.Second} {
+		step, ok := Validate(secret, Code(secret, now.Add(drift)), now)
+		if !ok || step != Step(now.Add(drift)) {
+			t.Errorf("code drifting %s was refused", drift)
+		}
+	}
+
+	if _, ok := Validate(secret, Code(secret, now.Add(2*Period*time.Second)), now); ok {
+		t.Errorf("code two steps ahead was accepted")
+	}
+
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Errorf("short code was accepted")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("vernacular", "foo@bar.com", []byte("12345678901234567890"))
+	if !strings.HasPrefix(uri, "otpauth://totp/vernacular:foo@bar.com?") || !strings.Contains(uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ") {
+		t.Errorf("got %s", uri)
+	}
+}